@@ -2,14 +2,22 @@ package api
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/aljabri00056/installer/handler"
 )
 
-func Handler(w http.ResponseWriter, r *http.Request) {
-	c := handler.GetConfigFromEnv()
-
-	h := &handler.Handler{Config: c}
+var (
+	sharedOnce sync.Once
+	shared     *handler.Handler
+)
 
-	h.ServeHTTP(w, r)
+// Handler is the serverless entrypoint. Warm invocations reuse the same
+// handler, so its cache, providers and parsed templates survive between
+// requests.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	sharedOnce.Do(func() {
+		shared = &handler.Handler{Config: handler.GetConfigFromEnv()}
+	})
+	shared.ServeHTTP(w, r)
 }
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aljabri00056/installer/handler/provider/providertest"
+)
+
+func TestHandlerReuse(t *testing.T) {
+	f := providertest.NewServer()
+	defer f.Close()
+	f.AddRelease("yudai", "gotty", providertest.Release{
+		Tag: "v0.0.13",
+		Assets: []providertest.Asset{
+			{Name: "gotty_linux_amd64.tar.gz", Data: providertest.TarGz(providertest.Program("gotty", "v0.0.13"))},
+		},
+	})
+	t.Setenv("GITHUB_API", f.GitHubAPI())
+	t.Setenv("DEFAULT_USER", "yudai")
+
+	get := func() {
+		r := httptest.NewRequest("GET", "/gotty", nil)
+		w := httptest.NewRecorder()
+		Handler(w, r)
+		if w.Result().StatusCode != 200 {
+			t.Fatalf("failed to get gotty: %s", w.Body.String())
+		}
+	}
+	get()
+	first := shared
+	before := f.Requests("yudai", "gotty")
+	get()
+	if shared != first {
+		t.Fatal("expected the handler to be reused")
+	}
+	// only the repo lookup, the release comes from the cache
+	if n := f.Requests("yudai", "gotty") - before; n != 1 {
+		t.Fatalf("expected a single request to the forge, got %d", n)
+	}
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The architectures are those of Go, except that 32-bit ARM is split by
+// version, armv5, armv6 and armv7, with armv6sf and armv7sf for the
+// soft-float builds of the latter two. armv5 is always soft-float, arm is
+// 32-bit ARM of an unknown version and universal is a macOS universal
+// binary.
+
+// archAliases maps the spellings of asset names to architectures
+var archAliases = map[string]string{
+	"amd64": "amd64", "x86_64": "amd64", "x86-64": "amd64", "x64": "amd64", "linux64": "amd64", "win64": "amd64",
+	"386": "386", "i386": "386", "i686": "386", "686": "386", "x86": "386", "linux32": "386", "win32": "386",
+	"arm64": "arm64", "aarch64": "arm64", "armv8": "arm64",
+	"armv7": "armv7", "armv7l": "armv7", "armv7a": "armv7", "armv7hf": "armv7", "arm-v7": "armv7", "arm7": "armv7", "armhf": "armv7", "armv7hl": "armv7",
+	"armv6": "armv6", "armv6l": "armv6", "armv6hf": "armv6", "arm-v6": "armv6", "arm6": "armv6", "armv6hl": "armv6",
+	"armv5": "armv5", "armv5l": "armv5", "armv5te": "armv5", "armv5tel": "armv5", "arm-v5": "armv5", "arm5": "armv5", "armel": "armv5",
+	"arm": "arm", "arm32": "arm",
+	"riscv64": "riscv64", "riscv64gc": "riscv64",
+	"ppc64le": "ppc64le", "ppc64el": "ppc64le", "powerpc64le": "ppc64le", "ppc64": "ppc64", "powerpc64": "ppc64",
+	"s390x": "s390x", "loong64": "loong64", "loongarch64": "loong64",
+	"mips64le": "mips64le", "mips64el": "mips64le", "mips64": "mips64",
+	"mipsle": "mipsle", "mipsel": "mipsle", "mips": "mips",
+	"universal": "universal", "universal2": "universal",
+}
+
+var (
+	// archRe finds the first architecture spelling of a name which is not
+	// part of a longer word, allowing levels such as x86_64v2
+	archRe = regexp.MustCompile(`(?:^|[^a-z0-9])(` + alternatives(archAliases) + `)(?:v[1-4])?(?:[^a-z0-9]|$)`)
+	// archWordRe is the fallback for names which run an architecture into
+	// a word, as in tool-amd64v3 or toolarm64. It only knows the spellings
+	// which are unlikely to be part of other words, unlike arm in charm.
+	archWordRe = regexp.MustCompile(`(x86_64|aarch64|linux64|amd64|arm64|armv8|armv7|win64|x64|386|686)`)
+	// universalRe matches goreleaser's name for universal binaries
+	universalRe = regexp.MustCompile(`(?:darwin|macos|osx)[_-]all(?:[^a-z0-9]|$)`)
+	// softFloatRe matches the soft-float ABIs of 32-bit ARM, as in the
+	// target triple armv7-unknown-linux-gnueabi
+	softFloatRe = regexp.MustCompile(`(?:^|[^a-z0-9])(?:gnueabi|musleabi|eabi|softfloat)(?:[^a-z0-9]|$)`)
+	// hardFloatRe matches the hard-float ABIs of 32-bit ARM
+	hardFloatRe = regexp.MustCompile(`(?:gnu|musl)?eabihf(?:[^a-z0-9]|$)`)
+)
+
+// alternatives joins the keys of m into a regexp alternation, longest
+// first so that x86_64 wins over x86
+func alternatives(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, regexp.QuoteMeta(k))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	return strings.Join(keys, "|")
+}
+
+func getArch(s string) string {
+	s = strings.ToLower(s)
+	if universalRe.MatchString(s) {
+		return "universal"
+	}
+	m := archRe.FindStringSubmatch(s)
+	if m == nil {
+		m = archWordRe.FindStringSubmatch(s)
+	}
+	if m == nil {
+		return ""
+	}
+	a := archAliases[m[1]]
+	switch {
+	case a == "arm" && hardFloatRe.MatchString(s):
+		// Rust's arm-unknown-linux-gnueabihf is ARMv6
+		a = "armv6"
+	case (a == "arm" || a == "armv6" || a == "armv7") && softFloatRe.MatchString(s):
+		if a == "arm" {
+			a = "armv6"
+		}
+		a += "sf"
+	}
+	return a
+}
+
+// archFallbacks are the architectures a host can run besides its own,
+// best first. 64-bit ARM kernels often run a 32-bit userland, static
+// 32-bit builds run on any 64-bit kernel and hard-float hosts run static
+// soft-float builds and vice versa.
+var archFallbacks = map[string][]string{
+	"amd64":    {"386"},
+	"arm64":    {"armv7", "armv6", "arm", "armv5"},
+	"armv7":    {"armv6", "arm", "armv5", "armv7sf", "armv6sf"},
+	"armv7sf":  {"armv6sf", "armv5", "arm", "armv7", "armv6"},
+	"armv6":    {"arm", "armv5", "armv6sf"},
+	"armv6sf":  {"armv5", "arm", "armv6"},
+	"armv5":    {"arm"},
+	"arm":      {"armv5"},
+	"mips64le": {"mipsle"},
+	"mips64":   {"mips"},
+}
+
+// osArchFallbacks come before archFallbacks on their OS: macOS runs
+// universal binaries and, with Rosetta, Intel ones on Apple silicon,
+// Windows on ARM emulates x64
+var osArchFallbacks = map[string]map[string][]string{
+	"darwin":  {"amd64": {"universal"}, "arm64": {"universal", "amd64"}},
+	"windows": {"arm64": {"amd64", "386"}},
+}
+
+// ArchChain lists the architectures of the assets a host of OS and Arch
+// can run, best first
+type ArchChain struct {
+	OS, Arch string
+	Archs    []string
+}
+
+// Key is the platform of the host as the install scripts spell it
+func (c ArchChain) Key() string {
+	return c.OS + "_" + c.Arch
+}
+
+// archChain returns the architectures a host of os and arch can run, its
+// own first
+func archChain(os, arch string) []string {
+	chain := []string{arch}
+	seen := map[string]bool{arch: true}
+	fallbacks := append([]string{}, osArchFallbacks[os][arch]...)
+	// macOS runs no 32-bit code
+	if os != "darwin" {
+		fallbacks = append(fallbacks, archFallbacks[arch]...)
+	}
+	for _, a := range fallbacks {
+		if !seen[a] {
+			seen[a] = true
+			chain = append(chain, a)
+		}
+	}
+	return chain
+}
+
+// ArchChains are the chains of the hosts which can fall back to another
+// architecture the release has assets for. A chain starts with the host's
+// own architecture and lists only the fallbacks present on its OS, hosts
+// without any are left to the scripts' default of their own.
+func (r Result) ArchChains() []ArchChain {
+	oses := []string{}
+	present := map[string]map[string]bool{}
+	for _, a := range r.Assets {
+		if present[a.OS] == nil {
+			present[a.OS] = map[string]bool{}
+			oses = append(oses, a.OS)
+		}
+		present[a.OS][a.Arch] = true
+	}
+	chains := []ArchChain{}
+	for _, os := range oses {
+		hostArchs := []string{}
+		for arch := range archFallbacks {
+			hostArchs = append(hostArchs, arch)
+		}
+		for arch := range osArchFallbacks[os] {
+			if _, ok := archFallbacks[arch]; !ok {
+				hostArchs = append(hostArchs, arch)
+			}
+		}
+		sort.Strings(hostArchs)
+		for _, arch := range hostArchs {
+			chain := archChain(os, arch)
+			archs := []string{arch}
+			for _, a := range chain[1:] {
+				if present[os][a] {
+					archs = append(archs, a)
+				}
+			}
+			if len(archs) > 1 {
+				chains = append(chains, ArchChain{OS: os, Arch: arch, Archs: archs})
+			}
+		}
+	}
+	return chains
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aljabri00056/installer/handler/provider"
+)
+
+func TestArchSpellings(t *testing.T) {
+	tests := []struct {
+		file, arch string
+	}{
+		{"gotty_linux_amd64.tar.gz", "amd64"},
+		{"micro-2.0.13-linux64.tar.gz", "amd64"},
+		{"ripgrep-14.1.0-x86_64-unknown-linux-musl.tar.gz", "amd64"},
+		{"ripgrep-14.1.0-i686-pc-windows-msvc.zip", "386"},
+		{"tool-windows-x86.zip", "386"},
+		{"hugo_0.120.0_linux-arm64.tar.gz", "arm64"},
+		{"ripgrep-14.1.0-aarch64-apple-darwin.tar.gz", "arm64"},
+		{"tool_linux_armv7.tar.gz", "armv7"},
+		{"tool-linux-arm-v7.tar.gz", "armv7"},
+		{"tool_1.0.0_armhf.tar.gz", "armv7"},
+		{"ripgrep-14.1.0-armv7-unknown-linux-gnueabihf.tar.gz", "armv7"},
+		{"bat-v0.24.0-armv7-unknown-linux-musleabi.tar.gz", "armv7sf"},
+		{"tool_linux_armv6.tar.gz", "armv6"},
+		{"bat-v0.24.0-arm-unknown-linux-gnueabihf.tar.gz", "armv6"},
+		{"bat-v0.24.0-arm-unknown-linux-gnueabi.tar.gz", "armv6sf"},
+		{"tool_linux_armv5.tar.gz", "armv5"},
+		{"tool_1.0.0_armel.tar.gz", "armv5"},
+		{"gotty_linux_arm.tar.gz", "arm"},
+		{"tool_linux_riscv64.tar.gz", "riscv64"},
+		{"tool-riscv64gc-unknown-linux-gnu.tar.gz", "riscv64"},
+		{"tool_linux_ppc64le.tar.gz", "ppc64le"},
+		{"tool-powerpc64le-unknown-linux-gnu.tar.gz", "ppc64le"},
+		{"tool_linux_s390x.tar.gz", "s390x"},
+		{"tool_linux_loong64.tar.gz", "loong64"},
+		{"tool-loongarch64-unknown-linux-gnu.tar.gz", "loong64"},
+		{"tool_linux_mips.tar.gz", "mips"},
+		{"tool_linux_mipsle.tar.gz", "mipsle"},
+		{"tool-mipsel-unknown-linux-musl.tar.gz", "mipsle"},
+		{"tool_linux_mips64le.tar.gz", "mips64le"},
+		{"tool_Darwin_all.tar.gz", "universal"},
+		{"tool-macos-universal.zip", "universal"},
+		{"charm_linux_amd64.tar.gz", "amd64"},
+		{"tool-linux-amd64v3.tar.gz", "amd64"},
+		{"toolarm64.tar.gz", "arm64"},
+		{"tool-linux-arm64v8.tar.gz", "arm64"},
+		{"tool_linux_x86_64v2.tar.gz", "amd64"},
+		{"charm.tar.gz", ""},
+		{"tool.tar.gz", ""},
+	}
+	for _, tc := range tests {
+		if arch := getArch(tc.file); arch != tc.arch {
+			t.Fatalf("getArch(%s) = %s, want %s", tc.file, arch, tc.arch)
+		}
+	}
+}
+
+func TestArchChain(t *testing.T) {
+	tests := []struct {
+		os, arch string
+		chain    []string
+	}{
+		{"linux", "amd64", []string{"amd64", "386"}},
+		{"linux", "armv7", []string{"armv7", "armv6", "arm", "armv5", "armv7sf", "armv6sf"}},
+		{"linux", "arm64", []string{"arm64", "armv7", "armv6", "arm", "armv5"}},
+		{"linux", "riscv64", []string{"riscv64"}},
+		{"darwin", "arm64", []string{"arm64", "universal", "amd64"}},
+		{"darwin", "amd64", []string{"amd64", "universal"}},
+		{"windows", "arm64", []string{"arm64", "amd64", "386", "armv7", "armv6", "arm", "armv5"}},
+	}
+	for _, tc := range tests {
+		if chain := archChain(tc.os, tc.arch); !reflect.DeepEqual(chain, tc.chain) {
+			t.Fatalf("archChain(%s, %s) = %v, want %v", tc.os, tc.arch, chain, tc.chain)
+		}
+	}
+}
+
+func TestArchChains(t *testing.T) {
+	r := Result{Assets: []provider.Asset{
+		{OS: "linux", Arch: "amd64"},
+		{OS: "linux", Arch: "armv6"},
+		{OS: "darwin", Arch: "amd64"},
+	}}
+	want := []ArchChain{
+		{OS: "linux", Arch: "arm64", Archs: []string{"arm64", "armv6"}},
+		{OS: "linux", Arch: "armv6sf", Archs: []string{"armv6sf", "armv6"}},
+		{OS: "linux", Arch: "armv7", Archs: []string{"armv7", "armv6"}},
+		{OS: "linux", Arch: "armv7sf", Archs: []string{"armv7sf", "armv6"}},
+		{OS: "darwin", Arch: "arm64", Archs: []string{"arm64", "amd64"}},
+	}
+	if chains := r.ArchChains(); !reflect.DeepEqual(chains, want) {
+		t.Fatalf("ArchChains() = %v, want %v", chains, want)
+	}
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aljabri00056/installer/handler/provider"
+)
+
+// FileType is a kind of release asset getAssets accepts. The install
+// scripts decompress and unpack assets as their type says.
+type FileType struct {
+	// Ext is the file name extension, as in .tar.gz
+	Ext string
+	// Archive is how the file is unpacked, tar, zip or 7z, empty for a
+	// single file
+	Archive string
+	// Compression is the compressor of the file or the tar, gzip, bzip2,
+	// xz or zstd, which is also the command decompressing it
+	Compression string
+	// OS is the only OS the type is for, windows for .exe and .msi
+	OS string
+	// Score ranks the types of one platform. Archives usually carry the
+	// whole release, single compressed files and bare binaries just the
+	// program, and few hosts have 7-Zip.
+	Score int
+}
+
+// fileTypes are the types getAssets accepts. Packages, accepted with
+// ?pkg=, have a platform of their own and only compete with each other.
+var fileTypes = []FileType{
+	{Ext: ".tar.gz", Archive: "tar", Compression: "gzip", Score: 2},
+	{Ext: ".tgz", Archive: "tar", Compression: "gzip", Score: 2},
+	{Ext: ".tar.bz2", Archive: "tar", Compression: "bzip2", Score: 2},
+	{Ext: ".tar.bz", Archive: "tar", Compression: "bzip2", Score: 2},
+	{Ext: ".tbz2", Archive: "tar", Compression: "bzip2", Score: 2},
+	{Ext: ".tar.xz", Archive: "tar", Compression: "xz", Score: 2},
+	{Ext: ".txz", Archive: "tar", Compression: "xz", Score: 2},
+	{Ext: ".tar.zst", Archive: "tar", Compression: "zstd", Score: 2},
+	{Ext: ".tzst", Archive: "tar", Compression: "zstd", Score: 2},
+	{Ext: ".tar", Archive: "tar", Score: 2},
+	{Ext: ".zip", Archive: "zip", Score: 2},
+	{Ext: ".7z", Archive: "7z", Score: 1},
+	{Ext: ".gz", Compression: "gzip", Score: 1},
+	{Ext: ".bz2", Compression: "bzip2", Score: 1},
+	{Ext: ".xz", Compression: "xz", Score: 1},
+	{Ext: ".zst", Compression: "zstd", Score: 1},
+	{Ext: ".bin", Score: 1},
+	{Ext: ".exe", OS: "windows", Score: 1},
+	{Ext: ".msi", OS: "windows", Score: 0},
+	{Ext: ".deb", Score: 2},
+	{Ext: ".rpm", Score: 2},
+	{Ext: ".apk", Score: 2},
+	{Ext: ".appimage", Score: 2},
+}
+
+// fileTypesBySuffix are fileTypes longest extension first, so that .tar.gz
+// wins over .gz
+var fileTypesBySuffix = func() []FileType {
+	types := append([]FileType{}, fileTypes...)
+	sort.SliceStable(types, func(i, j int) bool {
+		return len(types[i].Ext) > len(types[j].Ext)
+	})
+	return types
+}()
+
+// getFileType returns the type of the file called name
+func getFileType(name string) (FileType, bool) {
+	name = strings.ToLower(name)
+	for _, t := range fileTypesBySuffix {
+		if strings.HasSuffix(name, t.Ext) {
+			return t, true
+		}
+	}
+	return FileType{}, false
+}
+
+// FileTypes are the types the scripts unpack, packages are installed
+// separately
+func (r Result) FileTypes() []FileType {
+	types := []FileType{}
+	for _, t := range fileTypes {
+		if (provider.Asset{Type: t.Ext}).Package() == "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
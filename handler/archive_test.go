@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/aljabri00056/installer/handler/provider"
+)
+
+func TestFileType(t *testing.T) {
+	tests := []struct {
+		name, ext, archive, compression string
+	}{
+		{"ripgrep-14.1.0-x86_64-unknown-linux-musl.tar.gz", ".tar.gz", "tar", "gzip"},
+		{"zstd-v1.5.6-win64.zip", ".zip", "zip", ""},
+		{"tool_1.0.0_linux_amd64.tar.zst", ".tar.zst", "tar", "zstd"},
+		{"tool-linux-amd64.tzst", ".tzst", "tar", "zstd"},
+		{"tool-linux-amd64.zst", ".zst", "", "zstd"},
+		{"7z2408-linux-x64.tar.xz", ".tar.xz", "tar", "xz"},
+		{"tool-x86_64-linux.xz", ".xz", "", "xz"},
+		{"tool-linux-amd64.TXZ", ".txz", "tar", "xz"},
+		{"tool-1.0-windows-amd64.7z", ".7z", "7z", ""},
+		{"helix-24.07-x86_64-linux.tar.bz2", ".tar.bz2", "tar", "bzip2"},
+		{"tool_windows_amd64.exe", ".exe", "", ""},
+		{"tool-1.0-x64.msi", ".msi", "", ""},
+		{"my.file.tar.zip", ".zip", "zip", ""},
+	}
+	for _, tc := range tests {
+		ext := getFileExt(tc.name)
+		if ext != tc.ext {
+			t.Fatalf("%s: expected %q, got %q", tc.name, tc.ext, ext)
+		}
+		ft, ok := getFileType(ext)
+		if !ok || ft.Archive != tc.archive || ft.Compression != tc.compression {
+			t.Fatalf("%s: unexpected type %+v", tc.name, ft)
+		}
+	}
+	if _, ok := getFileType("tool_linux_amd64.tar.gz.sha256"); ok {
+		t.Fatal("expected checksums to have no type")
+	}
+}
+
+func TestFileTypes(t *testing.T) {
+	for _, ft := range (Result{}).FileTypes() {
+		if (provider.Asset{Type: ft.Ext}).Package() != "" {
+			t.Fatalf("expected no packages, got %s", ft.Ext)
+		}
+	}
+}
+
+func TestSelectFileTypes(t *testing.T) {
+	chosen, matches := selectAssets(Query{Program: "tool"}, []provider.Asset{
+		{Name: "tool_x86_64.exe", DownloadURL: "https://example.com/a"},
+		{Name: "tool_linux_amd64.exe", DownloadURL: "https://example.com/b"},
+		{Name: "tool-1.0-x64.msi", DownloadURL: "https://example.com/c"},
+		{Name: "tool_linux_arm64.7z", DownloadURL: "https://example.com/d"},
+		{Name: "tool_linux_arm64.tar.zst", DownloadURL: "https://example.com/e"},
+		{Name: "tool_darwin_arm64.xz", DownloadURL: "https://example.com/f"},
+	})
+	want := map[string]string{
+		"windows/amd64": "tool_x86_64.exe",
+		"linux/arm64":   "tool_linux_arm64.tar.zst",
+		"darwin/arm64":  "tool_darwin_arm64.xz",
+	}
+	if len(chosen) != len(want) {
+		t.Fatalf("expected %v, got %v", want, chosen)
+	}
+	for _, a := range chosen {
+		if want[a.Key()] != a.Name {
+			t.Fatalf("expected %s for %s, got %s", want[a.Key()], a.Key(), a.Name)
+		}
+	}
+	if s := matches[1].String(); s != "rejected tool_linux_amd64.exe: .exe files are for windows only" {
+		t.Fatalf("unexpected match %q", s)
+	}
+	if s := matches[2].String(); s[:7] != "skipped" {
+		t.Fatalf("expected the installer to lose to the binary, got %q", s)
+	}
+}
@@ -0,0 +1,220 @@
+// Package cache implements the release metadata cache. Entries live in a
+// size bounded Store and expire after a TTL. Concurrent loads of the same
+// key are coalesced and an expired value can be served when loading fails.
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Stats counts how an entry, or the whole cache, has been used by this
+// process
+type Stats struct {
+	// Hits is the number of lookups answered from the cache
+	Hits uint64
+	// Misses is the number of lookups which had to load the value or
+	// wait for a concurrent load of it
+	Misses uint64
+	// Stale is the number of lookups answered with an expired value
+	// because loading failed
+	Stale uint64
+	// Failed is the number of lookups which got the error of a load,
+	// with no value to serve. The stats of such a key are dropped with
+	// its entry, so only the totals count them.
+	Failed uint64
+}
+
+// call is an in-flight load shared by all concurrent callers
+type call[V any] struct {
+	wg    sync.WaitGroup
+	value V
+	err   error
+}
+
+// Cache is safe for concurrent use
+type Cache[V any] struct {
+	store    Store[V]
+	ttl      time.Duration
+	staleTTL time.Duration
+	now      func() time.Time
+
+	mut    sync.Mutex
+	stats  map[string]*Stats
+	totals Stats
+	calls  map[string]*call[V]
+}
+
+// New creates a cache over store whose entries are fresh for ttl. Expired
+// entries are kept for a further staleTTL and served only when reloading
+// them fails.
+func New[V any](store Store[V], ttl, staleTTL time.Duration) *Cache[V] {
+	return &Cache[V]{
+		store:    store,
+		ttl:      ttl,
+		staleTTL: staleTTL,
+		now:      time.Now,
+		stats:    map[string]*Stats{},
+		calls:    map[string]*call[V]{},
+	}
+}
+
+// Get returns the value stored under key if it is still fresh
+func (c *Cache[V]) Get(key string) (V, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if e, ok := c.lookup(key); ok && c.fresh(e) {
+		c.count(key, func(s *Stats) { s.Hits++ })
+		return e.Value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Set stores value under key
+func (c *Cache[V]) Set(key string, value V) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.set(key, value)
+}
+
+// Load returns the fresh value stored under key or calls load to get it.
+// Concurrent calls for the same key share a single call to load. When load
+// fails and an expired value is still within the stale window, the expired
+// value is returned instead of the error.
+func (c *Cache[V]) Load(key string, load func() (V, error)) (V, error) {
+	c.mut.Lock()
+	if e, ok := c.lookup(key); ok && c.fresh(e) {
+		c.count(key, func(s *Stats) { s.Hits++ })
+		c.mut.Unlock()
+		return e.Value, nil
+	}
+	if cl, ok := c.calls[key]; ok {
+		c.count(key, func(s *Stats) { s.Misses++ })
+		c.mut.Unlock()
+		cl.wg.Wait()
+		if cl.err != nil {
+			c.mut.Lock()
+			c.totals.Failed++
+			c.mut.Unlock()
+		}
+		return cl.value, cl.err
+	}
+	cl := &call[V]{}
+	cl.wg.Add(1)
+	c.calls[key] = cl
+	c.mut.Unlock()
+	// waiting callers are released even if load panics
+	defer func() {
+		c.mut.Lock()
+		delete(c.calls, key)
+		c.mut.Unlock()
+		cl.wg.Done()
+	}()
+
+	cl.value, cl.err = protect(key, load)
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if cl.err == nil {
+		c.set(key, cl.value)
+		c.count(key, func(s *Stats) { s.Misses++ })
+	} else if e, ok := c.lookup(key); ok {
+		// serve stale
+		c.count(key, func(s *Stats) {
+			s.Misses++
+			s.Stale++
+		})
+		cl.value, cl.err = e.Value, nil
+	} else {
+		// nothing is stored under key, not even the entry lookup
+		// removed before the load, so neither are its stats
+		c.totals.Misses++
+		c.totals.Failed++
+		delete(c.stats, key)
+	}
+	return cl.value, cl.err
+}
+
+// protect calls load, turning a panic into an error so that it reaches
+// every caller sharing the load
+func protect[V any](key string, load func() (V, error)) (value V, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("loading %s panicked: %v", key, r)
+		}
+	}()
+	return load()
+}
+
+// Stats returns the usage statistics of the entry stored under key
+func (c *Cache[V]) Stats(key string) (Stats, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if s, ok := c.stats[key]; ok {
+		return *s, true
+	}
+	return Stats{}, false
+}
+
+// Totals returns the usage statistics of all entries, including those
+// evicted since
+func (c *Cache[V]) Totals() Stats {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.totals
+}
+
+// Len returns the number of stored entries, including expired ones
+func (c *Cache[V]) Len() int {
+	return c.store.Len()
+}
+
+// lookup returns the entry stored under key, removing it if it is
+// past the stale window. The stats of the key are kept, so that they count
+// every reload, until the reload fails. Must hold c.mut.
+func (c *Cache[V]) lookup(key string) (Entry[V], bool) {
+	e, ok := c.store.Get(key)
+	if !ok {
+		return e, false
+	}
+	if !e.Stored.After(c.deadline()) {
+		c.store.Delete(key)
+		return e, false
+	}
+	return e, true
+}
+
+// set evicts entries past the stale window only once the store is full,
+// before the store makes room by evicting live ones. Must hold c.mut.
+func (c *Cache[V]) set(key string, value V) {
+	evicted := []string{}
+	if c.store.Full() {
+		evicted = c.store.Evict(c.deadline())
+	}
+	evicted = append(evicted, c.store.Set(key, Entry[V]{Value: value, Stored: c.now()})...)
+	for _, k := range evicted {
+		delete(c.stats, k)
+	}
+}
+
+// count updates the stats of key and the totals alike. Must hold c.mut.
+func (c *Cache[V]) count(key string, update func(*Stats)) {
+	s, ok := c.stats[key]
+	if !ok {
+		s = &Stats{}
+		c.stats[key] = s
+	}
+	update(s)
+	update(&c.totals)
+}
+
+func (c *Cache[V]) fresh(e Entry[V]) bool {
+	return c.now().Sub(e.Stored) < c.ttl
+}
+
+// deadline is the time before which entries are past the stale window
+func (c *Cache[V]) deadline() time.Time {
+	return c.now().Add(-c.ttl - c.staleTTL)
+}
@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newTestCache(size int, ttl, stale time.Duration) (*Cache[string], *clock) {
+	clk := &clock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := New[string](NewMemory[string](size), ttl, stale)
+	c.now = clk.now
+	return c, clk
+}
+
+func TestLRUEviction(t *testing.T) {
+	c, _ := newTestCache(2, time.Hour, 0)
+	c.Set("a", "1")
+	c.Set("b", "2")
+	c.Get("a") // b is now least recently used
+	c.Set("c", "3")
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := c.Get(k); !ok {
+			t.Fatalf("expected %s to be cached", k)
+		}
+	}
+	if c.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", c.Len())
+	}
+}
+
+func TestWeightedEviction(t *testing.T) {
+	m := NewWeightedMemory[string](10, 5, func(v string) int64 { return int64(len(v)) })
+	m.Set("a", Entry[string]{Value: "12"})
+	m.Set("b", Entry[string]{Value: "34"})
+	if evicted := m.Set("c", Entry[string]{Value: "56"}); len(evicted) != 1 || evicted[0] != "a" {
+		t.Fatalf("expected a to be evicted, got %v", evicted)
+	}
+	// replacing a value weighs it anew
+	if evicted := m.Set("b", Entry[string]{Value: "3"}); len(evicted) != 0 {
+		t.Fatalf("expected no evictions, got %v", evicted)
+	}
+	if evicted := m.Set("d", Entry[string]{Value: "123456"}); len(evicted) != 3 {
+		t.Fatalf("expected everything to be evicted, got %v", evicted)
+	}
+	if m.Len() != 0 || m.Full() {
+		t.Fatalf("expected an empty store, got %d entries", m.Len())
+	}
+}
+
+func TestTTLEviction(t *testing.T) {
+	c, clk := newTestCache(2, time.Minute, time.Minute)
+	c.Set("a", "1")
+	clk.t = clk.t.Add(90 * time.Second)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected a to be expired")
+	}
+	if c.Len() != 1 {
+		t.Fatal("expected stale entry to be kept")
+	}
+	c.Set("b", "2")
+	c.Get("a") // b is now least recently used
+	clk.t = clk.t.Add(time.Minute)
+	// a is past the stale window and goes before the live b
+	c.Set("c", "3")
+	if c.Len() != 2 {
+		t.Fatalf("expected dead entry to be evicted, have %d entries", c.Len())
+	}
+	if _, ok := c.store.Get("b"); !ok {
+		t.Fatal("expected b to be kept")
+	}
+}
+
+func TestStatsAcrossReloads(t *testing.T) {
+	c, clk := newTestCache(10, time.Minute, time.Minute)
+	load := func() (string, error) { return "v", nil }
+	for i := 0; i < 3; i++ {
+		c.Load("k", load)
+		// past the stale window, so the entry is removed on lookup
+		clk.t = clk.t.Add(3 * time.Minute)
+	}
+	if stats, _ := c.Stats("k"); stats.Misses != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestStatsOfFailedLoads(t *testing.T) {
+	c, clk := newTestCache(10, time.Minute, time.Minute)
+	c.Load("k", func() (string, error) { return "v", nil })
+	// past the stale window, the entry goes and the reload fails
+	clk.t = clk.t.Add(3 * time.Minute)
+	if _, err := c.Load("k", func() (string, error) { return "", errors.New("upstream down") }); err == nil {
+		t.Fatal("expected error without a stale value")
+	}
+	if stats, ok := c.Stats("k"); ok || len(c.stats) != 0 {
+		t.Fatalf("expected the stats to go with the entry, got %+v", stats)
+	}
+	if totals := c.Totals(); totals.Misses != 2 || totals.Failed != 1 {
+		t.Fatalf("unexpected totals: %+v", totals)
+	}
+}
+
+func TestLoadCoalescing(t *testing.T) {
+	c, _ := newTestCache(10, time.Hour, 0)
+	var loads int32
+	start := make(chan struct{})
+	load := func() (string, error) {
+		atomic.AddInt32(&loads, 1)
+		<-start
+		return "v", nil
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := c.Load("k", load); err != nil || v != "v" {
+				t.Errorf("unexpected result %q %v", v, err)
+			}
+		}()
+	}
+	// let the goroutines pile up on the in-flight load
+	time.Sleep(20 * time.Millisecond)
+	close(start)
+	wg.Wait()
+	if loads != 1 {
+		t.Fatalf("expected a single load, got %d", loads)
+	}
+	if v, _ := c.Load("k", load); v != "v" || loads != 1 {
+		t.Fatal("expected cached value")
+	}
+	// the waiters count as misses too
+	stats, _ := c.Stats("k")
+	if stats.Misses != 10 || stats.Hits != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestLoadStale(t *testing.T) {
+	c, clk := newTestCache(10, time.Minute, time.Hour)
+	fail := func() (string, error) { return "", errors.New("upstream down") }
+	if _, err := c.Load("k", fail); err == nil {
+		t.Fatal("expected error without a cached value")
+	}
+	c.Load("k", func() (string, error) { return "old", nil })
+	clk.t = clk.t.Add(2 * time.Minute)
+	v, err := c.Load("k", fail)
+	if err != nil || v != "old" {
+		t.Fatalf("expected stale value, got %q %v", v, err)
+	}
+	stats, _ := c.Stats("k")
+	if stats.Stale != 1 || stats.Misses != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	clk.t = clk.t.Add(2 * time.Hour)
+	if _, err := c.Load("k", fail); err == nil {
+		t.Fatal("expected error once the stale window has passed")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	clk := &clock{t: time.Now().Truncate(time.Second)}
+	open := func() *Cache[string] {
+		store, err := NewFile[string](dir, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := New[string](store, time.Minute, time.Minute)
+		c.now = clk.now
+		return c
+	}
+	c := open()
+	c.Set("a", "1")
+	clk.t = clk.t.Add(time.Second)
+	c.Set("b", "2")
+
+	// a restarted process sees the same entries
+	c = open()
+	for k, want := range map[string]string{"a": "1", "b": "2"} {
+		if v, ok := c.Get(k); !ok || v != want {
+			t.Fatalf("expected %s=%s after reopening, got %q %v", k, want, v, ok)
+		}
+	}
+	// oldest entry is evicted once full
+	clk.t = clk.t.Add(time.Second)
+	c.Set("c", "3")
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected a to be evicted")
+	}
+	if c.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", c.Len())
+	}
+	// and ttls still apply
+	clk.t = clk.t.Add(90 * time.Second)
+	if _, ok := c.Get("c"); ok {
+		t.Fatal("expected c to be expired")
+	}
+	v, err := c.Load("c", func() (string, error) { return "", errors.New("upstream down") })
+	if err != nil || v != "3" {
+		t.Fatalf("expected stale value, got %q %v", v, err)
+	}
+	clk.t = clk.t.Add(time.Hour)
+	c.Set("d", "4")
+	if c.Len() != 1 {
+		t.Fatalf("expected expired entries to be evicted, have %d", c.Len())
+	}
+}
+
+func TestLoadPanic(t *testing.T) {
+	c, _ := newTestCache(10, time.Hour, 0)
+	start := make(chan struct{})
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := c.Load("k", func() (string, error) {
+				<-start
+				panic("boom")
+			})
+			errs <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(start)
+	for i := 0; i < 5; i++ {
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Fatal("expected the panic as an error")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("callers blocked after a panicking load")
+		}
+	}
+	// the key is not stuck
+	if v, err := c.Load("k", func() (string, error) { return "v", nil }); err != nil || v != "v" {
+		t.Fatalf("unexpected result %q %v", v, err)
+	}
+}
@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aljabri00056/installer/logger"
+)
+
+// File is a Store keeping one JSON document per entry in a directory, so
+// that cached values survive restarts. The modification time of each file
+// is its stored time. Once it holds more than its size, the oldest entries
+// are removed. The number of entries is counted when the store is opened
+// and tracked in memory, so the directory is only listed to evict.
+type File[V any] struct {
+	dir   string
+	size  int
+	mut   sync.Mutex
+	count int
+}
+
+type fileEntry[V any] struct {
+	Key string `json:"key"`
+	Entry[V]
+}
+
+// NewFile creates a File store in dir, creating the directory if needed.
+// Entries already in dir are kept.
+func NewFile[V any](dir string, size int) (*File[V], error) {
+	if size < 1 {
+		size = 1
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	f := &File[V]{dir: dir, size: size}
+	files, err := f.list()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	f.count = len(files)
+	return f, nil
+}
+
+func (f *File[V]) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (f *File[V]) Get(key string) (Entry[V], bool) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	fe, err := f.read(f.path(key))
+	if err != nil || fe.Key != key {
+		if err != nil && !os.IsNotExist(err) {
+			logger.Warn("cache: %s", err)
+		}
+		return Entry[V]{}, false
+	}
+	return fe.Entry, true
+}
+
+func (f *File[V]) Set(key string, e Entry[V]) []string {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	_, err := os.Stat(f.path(key))
+	added := os.IsNotExist(err)
+	if err := f.write(key, e); err != nil {
+		logger.Warn("cache: %s", err)
+		return nil
+	}
+	if added {
+		f.count++
+	}
+	if f.count <= f.size {
+		return nil
+	}
+	files, err := f.list()
+	if err != nil {
+		logger.Warn("cache: %s", err)
+		return nil
+	}
+	f.count = len(files)
+	evicted := []string{}
+	for len(files) > f.size {
+		evicted = append(evicted, f.remove(files[0].path))
+		files = files[1:]
+	}
+	return evicted
+}
+
+func (f *File[V]) Delete(key string) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if os.Remove(f.path(key)) == nil {
+		f.count--
+	}
+}
+
+func (f *File[V]) Evict(before time.Time) []string {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	files, err := f.list()
+	if err != nil {
+		logger.Warn("cache: %s", err)
+		return nil
+	}
+	evicted := []string{}
+	for _, file := range files {
+		if !file.stored.Before(before) {
+			break
+		}
+		evicted = append(evicted, f.remove(file.path))
+	}
+	return evicted
+}
+
+func (f *File[V]) Len() int {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return f.count
+}
+
+func (f *File[V]) Full() bool {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return f.count >= f.size
+}
+
+func (f *File[V]) read(path string) (fileEntry[V], error) {
+	fe := fileEntry[V]{}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fe, err
+	}
+	if err := json.Unmarshal(b, &fe); err != nil {
+		return fe, fmt.Errorf("corrupt entry %s: %w", path, err)
+	}
+	return fe, nil
+}
+
+// write replaces the entry atomically, so readers in other
+// processes never see a partial file
+func (f *File[V]) write(key string, e Entry[V]) error {
+	b, err := json.Marshal(fileEntry[V]{Key: key, Entry: e})
+	if err != nil {
+		return fmt.Errorf("encode failed: %w", err)
+	}
+	tmp, err := os.CreateTemp(f.dir, ".entry-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	path := f.path(key)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return os.Chtimes(path, e.Stored, e.Stored)
+}
+
+// remove deletes the entry at path and returns its key
+func (f *File[V]) remove(path string) string {
+	fe, _ := f.read(path)
+	if os.Remove(path) == nil {
+		f.count--
+	}
+	return fe.Key
+}
+
+type storedFile struct {
+	path   string
+	stored time.Time
+}
+
+// list returns all entries, oldest first
+func (f *File[V]) list() ([]storedFile, error) {
+	dirents, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []storedFile{}
+	for _, d := range dirents {
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".json") {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, storedFile{
+			path:   filepath.Join(f.dir, d.Name()),
+			stored: info.ModTime(),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].stored.Before(files[j].stored) })
+	return files, nil
+}
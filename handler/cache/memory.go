@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type memoryItem[V any] struct {
+	key   string
+	entry Entry[V]
+}
+
+// Memory is an in-process Store which evicts the least recently used
+// entries once it holds more than its size, or more than its limit of
+// weight
+type Memory[V any] struct {
+	size  int
+	mut   sync.Mutex
+	lru   *list.List // of *memoryItem[V], most recently used first
+	items map[string]*list.Element
+	// weight, when set, measures values, such as their bytes
+	weight func(V) int64
+	limit  int64
+	total  int64
+}
+
+// NewMemory creates a Memory store holding at most size entries
+func NewMemory[V any](size int) *Memory[V] {
+	if size < 1 {
+		size = 1
+	}
+	return &Memory[V]{
+		size:  size,
+		lru:   list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+// NewWeightedMemory creates a Memory store holding at most size entries,
+// whose weights add up to at most limit
+func NewWeightedMemory[V any](size int, limit int64, weight func(V) int64) *Memory[V] {
+	m := NewMemory[V](size)
+	m.weight, m.limit = weight, limit
+	return m
+}
+
+func (m *Memory[V]) Get(key string) (Entry[V], bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	el, ok := m.items[key]
+	if !ok {
+		return Entry[V]{}, false
+	}
+	m.lru.MoveToFront(el)
+	return el.Value.(*memoryItem[V]).entry, true
+}
+
+func (m *Memory[V]) Set(key string, e Entry[V]) []string {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if el, ok := m.items[key]; ok {
+		item := el.Value.(*memoryItem[V])
+		m.total += m.weigh(e) - m.weigh(item.entry)
+		item.entry = e
+		m.lru.MoveToFront(el)
+	} else {
+		m.items[key] = m.lru.PushFront(&memoryItem[V]{key: key, entry: e})
+		m.total += m.weigh(e)
+	}
+	evicted := []string{}
+	for m.lru.Len() > m.size || (m.weight != nil && m.total > m.limit && m.lru.Len() > 0) {
+		evicted = append(evicted, m.remove(m.lru.Back()))
+	}
+	return evicted
+}
+
+func (m *Memory[V]) Delete(key string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if el, ok := m.items[key]; ok {
+		m.remove(el)
+	}
+}
+
+func (m *Memory[V]) Evict(before time.Time) []string {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	evicted := []string{}
+	for el := m.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if el.Value.(*memoryItem[V]).entry.Stored.Before(before) {
+			evicted = append(evicted, m.remove(el))
+		}
+		el = prev
+	}
+	return evicted
+}
+
+func (m *Memory[V]) Len() int {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.lru.Len()
+}
+
+func (m *Memory[V]) Full() bool {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.lru.Len() >= m.size || (m.weight != nil && m.total >= m.limit)
+}
+
+func (m *Memory[V]) weigh(e Entry[V]) int64 {
+	if m.weight == nil {
+		return 0
+	}
+	return m.weight(e.Value)
+}
+
+func (m *Memory[V]) remove(el *list.Element) string {
+	m.lru.Remove(el)
+	item := el.Value.(*memoryItem[V])
+	m.total -= m.weigh(item.entry)
+	delete(m.items, item.key)
+	return item.key
+}
@@ -0,0 +1,28 @@
+package cache
+
+import "time"
+
+// Entry is a value as held by a Store
+type Entry[V any] struct {
+	Value  V         `json:"value"`
+	Stored time.Time `json:"stored"`
+}
+
+// Store holds the entries of a Cache. Expiry is decided by the Cache, a
+// Store only has to bound its own size. Implementations must be safe for
+// concurrent use.
+type Store[V any] interface {
+	// Get returns the entry stored under key
+	Get(key string) (Entry[V], bool)
+	// Set stores e under key and returns the keys it evicted to make room
+	Set(key string, e Entry[V]) (evicted []string)
+	// Delete removes the entry stored under key
+	Delete(key string)
+	// Evict removes all entries stored before t and returns their keys
+	Evict(before time.Time) (evicted []string)
+	// Len returns the number of stored entries
+	Len() int
+	// Full reports whether the store holds as many entries as it may, so
+	// that storing a new one evicts another
+	Full() bool
+}
@@ -4,20 +4,44 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/aljabri00056/installer/handler/provider"
 )
 
 type Config struct {
 	Port        int               `opts:"help=port, env=HTTP_PORT"`
 	User        string            `opts:"help=default user when not provided in URL, env=DEFAULT_USER"`
-	Provider    string            `opts:"help=git provider (github,codeberg,forgejo), env=GIT_PROVIDER"`
+	Provider    string            `opts:"help=default provider or instance name (github,codeberg,forgejo,gitlab), env=GIT_PROVIDER"`
 	ProviderURL string            `opts:"help=base URL for forgejo/gitea instance, env=PROVIDER_URL"`
+	GitHubAPI   string            `opts:"help=GitHub API base URL (defaults to api.github.com), env=GITHUB_API"`
 	LogLevel    string            `opts:"help=log level (debug,info,warn,error), env=LOG_LEVEL"`
+	Timeout     time.Duration     `opts:"help=timeout for provider API requests, env=PROVIDER_TIMEOUT"`
+	Proxy       string            `opts:"help=proxy URL for provider API requests, env=PROVIDER_PROXY"`
+	CAFile      string            `opts:"help=PEM file with extra CA certificates for provider APIs, env=PROVIDER_CA_FILE"`
+	Cache       string            `opts:"help=cache backend (memory,file), env=CACHE"`
+	CacheDir    string            `opts:"help=directory of the file cache, env=CACHE_DIR"`
+	CacheSize   int               `opts:"help=maximum number of cached releases, env=CACHE_SIZE"`
+	CacheTTL    time.Duration     `opts:"help=how long release info is cached, env=CACHE_TTL"`
+	CacheStale  time.Duration     `opts:"help=how long expired release info may be served when the provider fails, env=CACHE_STALE"`
+	CacheSecret string            `opts:"help=secret keying cached private releases to their tokens, env=CACHE_SECRET"`
 	RepoPathMap map[string]string `opts:"help=Path mapping"`
+	// Tokens are server side tokens by instance name or host, a host
+	// takes precedence over the instance named after a type, as github.
+	// Several tokens for one key form a pool.
+	Tokens map[string][]string `opts:"help=server side tokens by provider or host, env=PROVIDER_TOKENS"`
+	// Instances are named forges, served as /<name>/user/repo
+	Instances []provider.Instance `opts:"help=named provider instances, env=PROVIDER_INSTANCES"`
 }
 
 var DefaultConfig = Config{
-	Port:     8080,
-	LogLevel: "info",
+	Port:       8080,
+	LogLevel:   "info",
+	Timeout:    30 * time.Second,
+	Cache:      "memory",
+	CacheSize:  1000,
+	CacheTTL:   time.Hour,
+	CacheStale: 24 * time.Hour,
 }
 
 func GetConfigFromEnv() Config {
@@ -37,6 +61,44 @@ func GetConfigFromEnv() Config {
 	if providerURL := getEnv("PROVIDER_URL", ""); providerURL != "" {
 		config.ProviderURL = providerURL
 	}
+	if githubAPI := getEnv("GITHUB_API", ""); githubAPI != "" {
+		config.GitHubAPI = githubAPI
+	}
+	if timeout := getEnv("PROVIDER_TIMEOUT", ""); timeout != "" {
+		if d, err := time.ParseDuration(timeout); err == nil {
+			config.Timeout = d
+		}
+	}
+	if proxy := getEnv("PROVIDER_PROXY", ""); proxy != "" {
+		config.Proxy = proxy
+	}
+	if caFile := getEnv("PROVIDER_CA_FILE", ""); caFile != "" {
+		config.CAFile = caFile
+	}
+	if backend := getEnv("CACHE", ""); backend != "" {
+		config.Cache = backend
+	}
+	if dir := getEnv("CACHE_DIR", ""); dir != "" {
+		config.CacheDir = dir
+	}
+	if size := getEnv("CACHE_SIZE", ""); size != "" {
+		if n, err := strconv.Atoi(size); err == nil {
+			config.CacheSize = n
+		}
+	}
+	if ttl := getEnv("CACHE_TTL", ""); ttl != "" {
+		if d, err := time.ParseDuration(ttl); err == nil {
+			config.CacheTTL = d
+		}
+	}
+	if stale := getEnv("CACHE_STALE", ""); stale != "" {
+		if d, err := time.ParseDuration(stale); err == nil {
+			config.CacheStale = d
+		}
+	}
+	if secret := getEnv("CACHE_SECRET", ""); secret != "" {
+		config.CacheSecret = secret
+	}
 	if logLevel := getEnv("LOG_LEVEL", "info"); logLevel != "" {
 		config.LogLevel = logLevel
 	}
@@ -51,9 +113,61 @@ func GetConfigFromEnv() Config {
 		}
 	}
 
+	// PROVIDER_INSTANCES=work=gitlab:https://gitlab.example.com,git=forgejo:https://git.example.com
+	if list := getEnv("PROVIDER_INSTANCES", ""); list != "" {
+		for _, entry := range strings.Split(list, ",") {
+			name, spec, ok := strings.Cut(entry, "=")
+			if !ok {
+				continue
+			}
+			typ, u, ok := strings.Cut(spec, ":")
+			if !ok {
+				continue
+			}
+			config.Instances = append(config.Instances, provider.Instance{
+				Name: strings.TrimSpace(name),
+				Type: strings.TrimSpace(typ),
+				URL:  strings.TrimSpace(u),
+			})
+		}
+	}
+
+	config.Tokens = make(map[string][]string)
+	for _, p := range []string{"github", "ghe", "gitlab", "codeberg", "forgejo"} {
+		if tokens := getEnv(strings.ToUpper(p)+"_TOKEN", ""); tokens != "" {
+			config.Tokens[p] = splitTokens(tokens, ",")
+		}
+	}
+	// S3 instances sign with the usual AWS credentials, but not with the
+	// role credentials of Lambda, ECS or EC2, which come with a session
+	// token and can not presign downloads
+	if id, secret := getEnv("AWS_ACCESS_KEY_ID", ""), getEnv("AWS_SECRET_ACCESS_KEY", ""); id != "" && secret != "" && getEnv("AWS_SESSION_TOKEN", "") == "" {
+		config.Tokens["s3"] = []string{id + ":" + secret}
+	}
+	// PROVIDER_TOKENS=gitlab=glpat-a,git.example.com=t1|t2
+	if mapStr := getEnv("PROVIDER_TOKENS", ""); mapStr != "" {
+		for _, mapping := range strings.Split(mapStr, ",") {
+			parts := strings.SplitN(mapping, "=", 2)
+			if len(parts) == 2 {
+				key := strings.ToLower(strings.TrimSpace(parts[0]))
+				config.Tokens[key] = append(config.Tokens[key], splitTokens(parts[1], "|")...)
+			}
+		}
+	}
+
 	return config
 }
 
+func splitTokens(s, sep string) []string {
+	tokens := []string{}
+	for _, t := range strings.Split(s, sep) {
+		if t = strings.TrimSpace(t); t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
 func getEnv(key string, defaultVal string) string {
 	if val := os.Getenv(key); val != "" {
 		return val
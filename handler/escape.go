@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are available to every installer template. Any value that
+// did not originate in this file must pass through one of the quoting funcs
+// before it is placed into a script.
+var templateFuncs = template.FuncMap{
+	"sh": shellQuote,
+	"ps": psQuote,
+}
+
+// shellQuote returns s as a single POSIX shell word. The result is wrapped
+// in single quotes, inside which nothing is special except the single quote
+// itself, which is closed, escaped and reopened.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// psSingleQuotes are the characters PowerShell accepts as a single quote,
+// including the typographic variants.
+const psSingleQuotes = "'‘’‚‛"
+
+// psQuote returns s as a PowerShell verbatim (single-quoted) string. Within
+// such a string the only escape is a doubled quote character.
+func psQuote(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('\'')
+	for _, r := range s {
+		if strings.ContainsRune(psSingleQuotes, r) {
+			b.WriteRune(r)
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/aljabri00056/installer/handler/provider"
+	"github.com/aljabri00056/installer/scripts"
+)
+
+// hostile returns values which execute "touch <dir>/pwned" if they are ever
+// interpreted by a shell instead of being treated as data.
+func hostile(dir string) []string {
+	touch := "touch " + filepath.Join(dir, "pwned")
+	return []string{
+		"$(" + touch + ")",
+		"`" + touch + "`",
+		"'; " + touch + "; '",
+		`"; ` + touch + `; "`,
+		"’; " + touch + "; ‘",
+		"x\n" + touch + "\n",
+		`\'; ` + touch + ` #`,
+	}
+}
+
+func renderScript(t *testing.T, script []byte, result Result) string {
+	t.Helper()
+	tmpl, err := template.New("installer").Funcs(templateFuncs).Parse(string(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buff := bytes.Buffer{}
+	if err := tmpl.Execute(&buff, result); err != nil {
+		t.Fatal(err)
+	}
+	return buff.String()
+}
+
+func TestShellQuote(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	dir := t.TempDir()
+	for _, v := range append(hostile(dir), "", "plain", "it's") {
+		out, err := exec.Command("bash", "-c", "printf %s "+shellQuote(v)).CombinedOutput()
+		if err != nil {
+			t.Fatalf("bash failed for %q: %s %s", v, err, out)
+		}
+		if string(out) != v {
+			t.Fatalf("shellQuote(%q) evaluated to %q", v, out)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Fatal("quoted value was executed")
+	}
+}
+
+func TestPowerShellQuote(t *testing.T) {
+	for _, v := range hostile(t.TempDir()) {
+		q := psQuote(v)
+		inner := []rune(q[1 : len(q)-1])
+		// every quote character inside the literal must be doubled,
+		// otherwise it would terminate the string
+		for i := 0; i < len(inner); i++ {
+			if !strings.ContainsRune(psSingleQuotes, inner[i]) {
+				continue
+			}
+			if i+1 == len(inner) || inner[i+1] != inner[i] {
+				t.Fatalf("psQuote(%q) = %s has an unescaped quote", v, q)
+			}
+			i++
+		}
+	}
+}
+
+func TestHostileScript(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("shell script only runs on posix hosts")
+	}
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	dir := t.TempDir()
+	// stub out the http clients so the script stops at the download
+	bin := filepath.Join(dir, "bin")
+	os.Mkdir(bin, 0o755)
+	for _, name := range []string{"curl", "wget"} {
+		os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\nexit 22\n"), 0o755)
+	}
+	for _, v := range hostile(dir) {
+		result := Result{
+			Query: Query{
+				User:       v,
+				Program:    v,
+				AsProgram:  v,
+				Release:    v,
+				MoveToPath: false,
+			},
+			Version: v,
+			Assets: []provider.Asset{{
+				OS:          runtime.GOOS,
+				Arch:        runtime.GOARCH,
+				Type:        ".tar.gz",
+				DownloadURL: "https://example.com/" + v,
+			}},
+		}
+		script := renderScript(t, scripts.LinuxShell, result)
+		if !strings.Contains(script, "USER="+shellQuote(v)+"\n") {
+			t.Fatalf("user not quoted in script:\n%s", script)
+		}
+		bash := exec.Command("bash")
+		bash.Stdin = strings.NewReader(script)
+		bash.Dir = dir
+		bash.Env = append(os.Environ(), "PATH="+bin+":"+os.Getenv("PATH"))
+		out, _ := bash.CombinedOutput()
+		if !strings.Contains(string(out), "download failed") {
+			t.Fatalf("expected script to reach the download step: %s", out)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+			t.Fatalf("value %q was executed by the shell script: %s", v, out)
+		}
+		ps := renderScript(t, scripts.WindowsShell, result)
+		if !strings.Contains(ps, "[string]$User = "+psQuote(v)+"\n") {
+			t.Fatalf("user not quoted in powershell script:\n%s", ps)
+		}
+	}
+}
+
+func TestQueryValidate(t *testing.T) {
+	valid := Query{User: "zyedidia", Program: "micro", Release: "v2.0.13", Platform: "linux"}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, v := range hostile(t.TempDir()) {
+		for name, q := range map[string]Query{
+			"user":    {User: v, Program: "micro", Platform: "linux"},
+			"program": {Program: v, Platform: "linux"},
+			"release": {Program: "micro", Release: v, Platform: "linux"},
+			"as":      {Program: "micro", AsProgram: v, Platform: "linux"},
+			"include": {Program: "micro", Include: v, Platform: "linux"},
+			"arch":    {Program: "micro", Arch: v, Platform: "linux"},
+		} {
+			if err := q.validate(); err == nil {
+				t.Fatalf("expected %s %q to be rejected", name, v)
+			}
+		}
+	}
+	for _, as := range []string{"..", "../bin", "a/b"} {
+		q := Query{Program: "micro", AsProgram: as, Platform: "linux"}
+		if err := q.validate(); err == nil {
+			t.Fatalf("expected as=%q to be rejected", as)
+		}
+	}
+}
+
+func TestServeRejectsHostileQuery(t *testing.T) {
+	h := &Handler{}
+	r := httptest.NewRequest("GET", "/zyedidia/micro?type=script&as=%24%28id%29", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Result().StatusCode != 400 {
+		t.Fatalf("expected bad request, got %d: %s", w.Result().StatusCode, w.Body.String())
+	}
+}
@@ -2,27 +2,33 @@ package handler
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
-	"os"
 	"sync"
 	"text/template"
 	"time"
 
 	"github.com/aljabri00056/installer/logger"
 
+	"github.com/aljabri00056/installer/handler/cache"
 	"github.com/aljabri00056/installer/handler/provider"
 	"github.com/aljabri00056/installer/scripts"
 )
 
 const (
-	cacheTTL = time.Hour
-
 	ErrInvalidPath     = "Invalid path - must specify program name"
 	ErrUnknownType     = "Unknown response type requested"
 	ErrUnknownProvider = "Unknown provider specified"
@@ -34,23 +40,46 @@ var (
 	errMsgRe = regexp.MustCompile(`[^A-Za-z0-9\ :\/\.]`)
 )
 
+// Query describes a requested install. It is cached and rendered as part of
+// Result, so it must never hold credentials.
 type Query struct {
-	User, Program, AsProgram, Release, Include, Arch, Token, Platform, ProviderURL string
-	MoveToPath, Insecure, Private                                                  bool
+	User, Program, AsProgram, Release, Channel, Include, Arch, Platform, ProviderURL string
+	MoveToPath, Insecure, Private                                                    bool
+
+	// Forge is the API spoken by the provider, e.g. github or gitlab
+	Forge string
+	// TokenURL is where the script gets a bearer token for downloads
+	TokenURL string
+	// Pkg asks for native packages or AppImages instead of binaries, one
+	// of auto, deb, rpm, apk or appimage
+	Pkg string
 }
 
 type Result struct {
 	Query
 	Timestamp time.Time
 	Assets    []provider.Asset
-	Version   string
-	M1Asset   bool
+	// Matches say why each asset of the release was chosen or not
+	Matches []Match
+	Version string
+	M1Asset bool
+	// Expires is when the first presigned asset URL stops working, zero
+	// if none does
+	Expires time.Time
 }
 
-func (q Query) cacheKey() string {
+// cacheKey identifies the release lookup of q. Only the fields which affect
+// the lookup are used, so results are shared no matter how callers want them
+// installed. Private results are additionally bound to scope, the
+// fingerprint of the token which was allowed to read them.
+func (q Query) cacheKey(scope string) string {
 	hw := sha256.New()
 	jw := json.NewEncoder(hw)
-	if err := jw.Encode(q); err != nil {
+	if err := jw.Encode(struct {
+		ProviderURL, User, Program, Release, Channel, Include, Pkg string
+		Private                                                    bool
+		Scope                                                      string
+	}{q.ProviderURL, q.User, q.Program, q.Release, q.Channel, q.Include, q.Pkg, q.Private, scope}); err != nil {
 		panic(err)
 	}
 	return base64.StdEncoding.EncodeToString(hw.Sum(nil))
@@ -59,36 +88,274 @@ func (q Query) cacheKey() string {
 // Handler serves install scripts using Github releases
 type Handler struct {
 	Config
-	cacheMut sync.Mutex
-	cache    map[string]Result
+
+	setupOnce sync.Once
+	setupErr  error
+	client    *http.Client
+	cache     *cache.Cache[Result]
+	secret    []byte
+	templates map[string]*template.Template
+	tokens    map[string]*provider.TokenPool
+	registry  *provider.Registry
+	// defaultInstance serves paths without an instance name, when it
+	// differs from the registered instance named by Config.Provider
+	defaultInstance provider.Instance
+}
+
+// setup builds the state shared by all requests, once
+func (h *Handler) setup() error {
+	h.setupOnce.Do(func() {
+		if h.client, h.setupErr = h.httpClient(); h.setupErr != nil {
+			return
+		}
+		if h.cache, h.setupErr = h.newCache(); h.setupErr != nil {
+			return
+		}
+		if h.templates, h.setupErr = parseTemplates(); h.setupErr != nil {
+			return
+		}
+		if h.registry, h.setupErr = h.newRegistry(); h.setupErr != nil {
+			return
+		}
+		all := map[string][]string{}
+		for key, tokens := range h.Config.Tokens {
+			key = strings.ToLower(key)
+			all[key] = append(all[key], tokens...)
+		}
+		for _, inst := range h.Config.Instances {
+			if len(inst.Tokens) > 0 {
+				key := strings.ToLower(inst.Name)
+				all[key] = append(all[key], inst.Tokens...)
+			}
+		}
+		h.tokens = map[string]*provider.TokenPool{}
+		for key, tokens := range all {
+			h.tokens[key] = provider.NewTokenPool(tokens...)
+		}
+		h.secret, h.setupErr = h.newSecret()
+	})
+	return h.setupErr
+}
+
+// parseTemplates parses the installer templates by file extension
+func parseTemplates() (map[string]*template.Template, error) {
+	templates := map[string]*template.Template{}
+	for ext, script := range map[string][]byte{
+		"sh":  scripts.LinuxShell,
+		"ps1": scripts.WindowsShell,
+		"txt": scripts.Text,
+	} {
+		t, err := template.New("installer." + ext).Funcs(templateFuncs).Parse(string(script))
+		if err != nil {
+			return nil, fmt.Errorf("installer BUG: %w", err)
+		}
+		templates[ext] = t
+	}
+	return templates, nil
+}
+
+// newSecret returns the key of the token fingerprints. It must outlive
+// the process when the cache does, or private entries of the file cache
+// could never be found again after a restart. It is derived from
+// CacheSecret, else kept next to the file cache, else random.
+func (h *Handler) newSecret() ([]byte, error) {
+	if h.Config.CacheSecret != "" {
+		sum := sha256.Sum256([]byte(h.Config.CacheSecret))
+		return sum[:], nil
+	}
+	secret := make([]byte, 32)
+	if !strings.EqualFold(h.Config.Cache, "file") {
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate secret: %w", err)
+		}
+		return secret, nil
+	}
+	path := filepath.Join(h.cacheDir(), "secret.key")
+	if b, err := os.ReadFile(path); err == nil && len(b) == len(secret) {
+		return b, nil
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+	if err := os.WriteFile(path, secret, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to store secret: %w", err)
+	}
+	return secret, nil
 }
 
-func (h *Handler) detectProvider(path string) (provider, user string) {
-	if path == "" {
-		return "github", ""
+// cacheDir is the directory of the file cache
+func (h *Handler) cacheDir() string {
+	if h.Config.CacheDir != "" {
+		return h.Config.CacheDir
 	}
+	return filepath.Join(os.TempDir(), "installer-cache")
+}
 
-	parts := strings.SplitN(path, "/", 2)
-	first := strings.ToLower(parts[0])
+// fingerprint identifies a token without revealing it
+func (h *Handler) fingerprint(token string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
 
-	switch first {
-	case "github", "codeberg", "forgejo", "gitlab":
-		if len(parts) > 1 {
-			return first, parts[1]
+// newCache builds the release cache, zero config values use the defaults
+func (h *Handler) newCache() (*cache.Cache[Result], error) {
+	size, ttl, stale := h.Config.CacheSize, h.Config.CacheTTL, h.Config.CacheStale
+	if size <= 0 {
+		size = DefaultConfig.CacheSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultConfig.CacheTTL
+	}
+	if stale < 0 {
+		stale = 0
+	}
+	var store cache.Store[Result]
+	switch strings.ToLower(h.Config.Cache) {
+	case "", "memory":
+		store = cache.NewMemory[Result](size)
+	case "file":
+		fs, err := cache.NewFile[Result](h.cacheDir(), size)
+		if err != nil {
+			return nil, err
 		}
-		return first, ""
+		store = fs
 	default:
-		if h.Config.Provider != "" {
-			return h.Config.Provider, path
+		return nil, fmt.Errorf("unknown cache backend: %s (supported: memory, file)", h.Config.Cache)
+	}
+	return cache.New[Result](store, ttl, stale), nil
+}
+
+// tokenPool returns the server side tokens for a provider instance. Tokens
+// of a named instance take precedence over those of its API or web host,
+// which take precedence over those of its type. The pools of a type, such
+// as GITLAB_TOKEN, are only for the instance named after the type, so that
+// they never reach other hosts of the type. AWS credentials are for any
+// bucket on AWS.
+func (h *Handler) tokenPool(inst provider.Instance) *provider.TokenPool {
+	keys := []string{}
+	if inst.Name != inst.Type {
+		keys = append(keys, inst.Name)
+	}
+	onAWS := false
+	for _, u := range []string{inst.API, inst.URL} {
+		if parsed, err := url.Parse(u); err == nil && parsed.Host != "" {
+			host := strings.ToLower(parsed.Hostname())
+			keys = append(keys, strings.ToLower(parsed.Host))
+			onAWS = onAWS || strings.HasSuffix(host, ".amazonaws.com")
+		}
+	}
+	if inst.Name == inst.Type || (inst.Type == "s3" && onAWS) {
+		keys = append(keys, inst.Type)
+	}
+	for _, key := range keys {
+		if pool, ok := h.tokens[key]; ok {
+			return pool
 		}
-		return "github", path
 	}
+	return nil
+}
+
+// newRegistry registers the public forges, the legacy PROVIDER_URL
+// instance and the configured instances, in that order. It sets the
+// default instance when that is not in the registry.
+func (h *Handler) newRegistry() (*provider.Registry, error) {
+	instances := append([]provider.Instance{}, provider.DefaultInstances...)
+	for i := range instances {
+		if instances[i].Name == "github" && h.Config.GitHubAPI != "" {
+			instances[i].API = h.Config.GitHubAPI
+		}
+	}
+	// PROVIDER_URL is the forgejo instance, unless gitlab is the default
+	// provider. Then it is the default instance, /gitlab/ still is gitlab.com.
+	if strings.EqualFold(h.Config.Provider, "gitlab") && h.Config.ProviderURL != "" {
+		h.defaultInstance = provider.Instance{Name: "gitlab", Type: "gitlab", URL: h.Config.ProviderURL}
+	} else {
+		instances = append(instances, provider.Instance{Name: "forgejo", Type: "forgejo", URL: h.Config.ProviderURL})
+	}
+	instances = append(instances, h.Config.Instances...)
+	return provider.NewRegistry(instances...)
+}
+
+// httpClient builds the client shared by every provider
+func (h *Handler) httpClient() (*http.Client, error) {
+	opts := []provider.Option{}
+	if h.Config.Timeout > 0 {
+		opts = append(opts, provider.WithTimeout(h.Config.Timeout))
+	}
+	if h.Config.Proxy != "" {
+		u, err := url.Parse(h.Config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		opts = append(opts, provider.WithProxy(u))
+	}
+	if h.Config.CAFile != "" {
+		pem, err := os.ReadFile(h.Config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", h.Config.CAFile)
+		}
+		opts = append(opts, provider.WithRootCAs(pool))
+	}
+	return provider.NewHTTPClient(opts...), nil
+}
+
+// detectProvider splits the instance name, or the host of a configured
+// instance, off path. Paths without either belong to the default instance.
+func (h *Handler) detectProvider(path string) (inst provider.Instance, rest string, ok bool) {
+	first, rest := splitHalf(path, "/")
+	if inst, ok := h.registry.Lookup(first); ok && first != "" && (h.reserved(inst.Name) || strings.Contains(rest, "/")) {
+		return inst, rest, true
+	}
+	if strings.Contains(first, ".") {
+		if inst, ok := h.registry.LookupHost(first); ok {
+			return inst, rest, true
+		}
+	}
+	if h.defaultInstance.Name != "" {
+		return h.defaultInstance, path, true
+	}
+	name := "github"
+	if h.Config.Provider != "" {
+		name = h.Config.Provider
+	}
+	inst, ok = h.registry.Lookup(name)
+	return inst, path, ok
+}
+
+// reserved reports whether an instance name always is a path prefix. The
+// public forges added after github, codeberg, gitlab and forgejo only
+// prefix paths with more than one segment left, unless configured by name,
+// so that /bitbucket/<repo> still is the GitHub user bitbucket.
+func (h *Handler) reserved(name string) bool {
+	switch name {
+	case "github", "codeberg", "gitlab", "forgejo":
+		return true
+	}
+	for _, inst := range h.Config.Instances {
+		if strings.EqualFold(strings.TrimSpace(inst.Name), name) {
+			return true
+		}
+	}
+	for _, inst := range provider.DefaultInstances {
+		if inst.Name == name {
+			return false
+		}
+	}
+	return true
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	// calculate response type
 	ext := ""
-	script := ""
 	qtype := r.URL.Query().Get("type")
 	if qtype == "" {
 		ua := r.Header.Get("User-Agent")
@@ -108,6 +375,15 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		http.Error(w, cleaned, code)
 	}
+	// forge rate limits are passed on, so clients know when to come back
+	showProviderError := func(err error, code int) {
+		var rl *provider.RateLimitError
+		if errors.As(err, &rl) {
+			w.Header().Set("Retry-After", strconv.Itoa(rl.Seconds()))
+			code = http.StatusTooManyRequests
+		}
+		showError(err.Error(), code)
+	}
 
 	q := Query{
 		User:      "",
@@ -115,13 +391,19 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Release:   "",
 		Insecure:  r.URL.Query().Get("insecure") == "1",
 		AsProgram: r.URL.Query().Get("as"),
+		Channel:   r.URL.Query().Get("channel"),
 		Include:   r.URL.Query().Get("include"),
+		Pkg:       r.URL.Query().Get("pkg"),
 		Arch:      r.URL.Query().Get("arch"),
 		Platform:  r.URL.Query().Get("platform"),
 	}
 	if q.Platform == "" {
 		q.Platform = "linux"
 	}
+	// ?arch= takes any spelling of asset names, as in aarch64
+	if a := getArch(q.Arch); a != "" {
+		q.Arch = a
+	}
 	if r.URL.Query().Get("move") == "" {
 		q.MoveToPath = true
 	} else {
@@ -132,16 +414,13 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if q.Platform == "windows" {
 			w.Header().Set("Content-Type", "text/x-powershell")
 			ext = "ps1"
-			script = string(scripts.WindowsShell)
 		} else {
 			w.Header().Set("Content-Type", "text/x-shellscript")
 			ext = "sh"
-			script = string(scripts.LinuxShell)
 		}
 	case "text":
 		w.Header().Set("Content-Type", "text/plain")
 		ext = "txt"
-		script = string(scripts.Text)
 	default:
 		showError("Unknown type", http.StatusInternalServerError)
 		return
@@ -153,38 +432,54 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		path = p
 	}
 
-	detectedProvider, remainingPath := h.detectProvider(path)
-
-	switch detectedProvider {
-	case "github":
-		q.ProviderURL = "https://github.com"
-	case "codeberg":
-		q.ProviderURL = "https://codeberg.org"
-	case "gitlab":
-		q.ProviderURL = "https://gitlab.com"
-	case "forgejo":
-		q.ProviderURL = h.Config.ProviderURL
-	default:
+	if err := h.setup(); err != nil {
+		logger.Error("setup failed: %s", err)
+		showError("Server misconfigured", http.StatusInternalServerError)
+		return
+	}
+	inst, remainingPath, ok := h.detectProvider(path)
+	if !ok {
 		showError("Unknown provider", http.StatusBadRequest)
 		return
 	}
+	q.ProviderURL = inst.URL
+	q.Forge = inst.Type
+	if inst.Type == "github" && inst.URL != "" && inst.Host() != "github.com" {
+		q.Forge = "ghe"
+	}
 
-	var rest string
-	q.User, rest = splitHalf(remainingPath, "/")
-	q.Program, q.Release = splitHalf(rest, "@")
+	var repoPath string
+	repoPath, q.Release = splitHalf(remainingPath, "@")
+	q.User, q.Program = splitHalf(repoPath, "/")
+	switch inst.Type {
+	case "gitlab", "oci":
+		// the project is the last part, after any nested groups or namespaces
+		if i := strings.LastIndex(repoPath, "/"); i >= 0 {
+			q.User, q.Program = repoPath[:i], repoPath[i+1:]
+		}
+	case "srht":
+		// sr.ht writes users as ~user
+		q.User = strings.TrimPrefix(q.User, "~")
+	case "http", "s3":
+		// file servers and buckets only have tools
+		q.User, q.Program = "", repoPath
+	}
 
 	// no program? treat first part as program, use default user
-	if q.Program == "" {
+	if q.Program == "" && q.User != "" {
 		q.Program = q.User
 		q.User = h.Config.User
 	}
 
-	if h.Config.Provider != "" {
-		detectedProvider = h.Config.Provider
-	}
 	if q.Release == "" {
 		q.Release = "latest"
 	}
+	if q.Release == "latest-pre" {
+		q.Release = "latest"
+		if q.Channel == "" {
+			q.Channel = channelPrerelease
+		}
+	}
 
 	valid := q.Program != ""
 	if !valid {
@@ -196,37 +491,46 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		showError("Invalid path - must specify program name", http.StatusBadRequest)
 		return
 	}
+	if err := q.validate(); err != nil {
+		logger.Debug("invalid query: %s", err)
+		showError(err.Error(), http.StatusBadRequest)
+		return
+	}
 
+	pool := h.tokenPool(inst)
+	token := ""
 	split := strings.Split(r.Header.Get("Authorization"), "Bearer ")
 	if len(split) > 1 {
-		q.Token = split[1]
+		token = split[1]
 	}
-	if q.Token == "" && detectedProvider == "github" {
-	  q.Token = os.Getenv("GITHUB_TOKEN")
+	// GitLab CI jobs may pass on their job token as GitLab expects it
+	if job := r.Header.Get("Job-Token"); token == "" && job != "" && inst.Type == "gitlab" {
+		token = provider.JobTokenPrefix + job
 	}
-	provider, err := provider.NewProvider(detectedProvider, h.Config.ProviderURL)
-	if err != nil {
-		showError(err.Error(), http.StatusBadRequest)
-		return
+	if token == "" {
+		token = pool.Token()
 	}
-	res, err := provider.GetRepo(q.User, q.Program, q.Token)
+	provider, err := inst.NewProvider(provider.WithHTTPClient(h.client), provider.WithRateLimitHook(pool.Observe))
 	if err != nil {
 		showError(err.Error(), http.StatusBadRequest)
 		return
 	}
-	q.Private = res.Private
-	result, err := h.execute(provider, q)
+	// this is also the permission check for cached private results,
+	// the token must still be able to see the repository
+	res, err := provider.GetRepo(ctx, q.User, q.Program, token)
 	if err != nil {
-		showError(err.Error(), http.StatusBadGateway)
+		showProviderError(err, http.StatusBadRequest)
 		return
 	}
-	t, err := template.New("installer").Parse(script)
+	q.Private = res.Private
+	q.TokenURL = res.TokenURL
+	result, err := h.execute(ctx, provider, q, token)
 	if err != nil {
-		showError("installer BUG: "+err.Error(), http.StatusInternalServerError)
+		showProviderError(err, http.StatusBadGateway)
 		return
 	}
 	buff := bytes.Buffer{}
-	if err := t.Execute(&buff, result); err != nil {
+	if err := h.templates[ext].Execute(&buff, result); err != nil {
 		showError("Template error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
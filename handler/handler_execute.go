@@ -1,28 +1,54 @@
 package handler
 
 import (
+	"context"
 	"errors"
-	"strings"
 	"time"
 
 	"github.com/aljabri00056/installer/handler/provider"
+	"github.com/aljabri00056/installer/handler/semver"
 	"github.com/aljabri00056/installer/logger"
 )
 
-func (h *Handler) execute(provider provider.Provider, q Query) (Result, error) {
-	key := q.cacheKey()
-	h.cacheMut.Lock()
-	if h.cache == nil {
-		h.cache = map[string]Result{}
+// expiryMargin is how long before its presigned URLs expire a cached
+// result is reloaded, so that scripts have time to download
+const expiryMargin = time.Hour
+
+func (h *Handler) execute(ctx context.Context, provider provider.Provider, q Query, token string) (Result, error) {
+	scope := ""
+	if q.Private {
+		scope = h.fingerprint(token)
+	}
+	key := q.cacheKey(scope)
+	// the load is shared by concurrent identical requests, it must
+	// not be cancelled when the first of them goes away
+	ctx = context.WithoutCancel(ctx)
+	result, err := h.cache.Load(key, func() (Result, error) {
+		return h.fetch(ctx, provider, q, token)
+	})
+	if err != nil {
+		return Result{}, err
 	}
-	cached, ok := h.cache[key]
-	h.cacheMut.Unlock()
-	if ok && time.Since(cached.Timestamp) < cacheTTL {
-		return cached, nil
+	// presigned URLs must outlive the cached result, whatever the TTL
+	if !result.Expires.IsZero() && time.Until(result.Expires) < expiryMargin {
+		if result, err = h.fetch(ctx, provider, q, token); err != nil {
+			return Result{}, err
+		}
+		h.cache.Set(key, result)
+	}
+	// the cached result may have been fetched for a different caller
+	result.Query = q
+	if stats, ok := h.cache.Stats(key); ok {
+		logger.Debug("cache %s/%s@%s: %d hits, %d misses, %d stale", q.User, q.Program, q.Release, stats.Hits, stats.Misses, stats.Stale)
 	}
+	return result, nil
+}
+
+// fetch looks up the release assets from the provider, bypassing the cache
+func (h *Handler) fetch(ctx context.Context, provider provider.Provider, q Query, token string) (Result, error) {
 	ts := time.Now()
 
-	release, assets, err := h.getAssets(provider, q)
+	release, assets, matches, err := h.getAssets(ctx, provider, q, token)
 
 	if err != nil {
 		return Result{}, err
@@ -42,90 +68,61 @@ func (h *Handler) execute(provider provider.Provider, q Query) (Result, error) {
 		Timestamp: ts,
 		Query:     q,
 		Assets:    assets,
+		Matches:   matches,
 		Version:   release,
 		M1Asset:   hasM1Asset,
 	}
-
-	h.cacheMut.Lock()
-	h.cache[key] = result
-	h.cacheMut.Unlock()
+	for _, a := range assets {
+		if !a.Expires.IsZero() && (result.Expires.IsZero() || a.Expires.Before(result.Expires)) {
+			result.Expires = a.Expires
+		}
+	}
 	return result, nil
 }
 
-func (h *Handler) getAssets(_provider provider.Provider, q Query) (string, []provider.Asset, error) {
+// getAssets returns the version of the release and the asset chosen for
+// each of its platforms, along with the matches of all its assets
+func (h *Handler) getAssets(ctx context.Context, _provider provider.Provider, q Query, token string) (string, []provider.Asset, []Match, error) {
 	user := q.User
 	repo := q.Program
 	release := q.Release
 
+	switch {
+	case semver.IsConstraint(release):
+		tag, err := resolveConstraint(ctx, _provider, q, token)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		release = tag
+	case release == "latest" && q.Channel != "" && q.Channel != channelStable:
+		tag, err := resolveChannel(ctx, _provider, q, token)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		release = tag
+	}
+
 	logger.Debug("fetching asset info for %s/%s@%s", user, repo, release)
 
-	version, assets, err := _provider.GetReleaseAssets(user, repo, release, q.Token)
+	version, assets, err := _provider.GetReleaseAssets(ctx, user, repo, release, token)
 	if err != nil {
-		return "", nil, err
+		return "", nil, nil, err
 	}
 
 	if len(assets) == 0 {
-		return version, nil, errors.New("no assets found")
+		return version, nil, nil, errors.New("no assets found")
 	}
 
-	filtered := []provider.Asset{}
-	index := map[string]bool{}
-
-	for _, asset := range assets {
-		if q.Token != "" && q.Private {
-			asset.DownloadURL = asset.URL
-		}
-		fext := getFileExt(asset.Name)
-		if fext == "" && asset.Size > 1024*1024 {
-			fext = ".bin" // +1MB binary
-		}
-
-		switch fext {
-		case ".bin", ".zip", ".tar.bz", ".tar.bz2", ".bz2", ".gz", ".tar.gz", ".tgz", ".tar.xz":
-		default:
-			logger.Debug("fetched asset has unsupported file type: %s (ext '%s')", asset.Name, fext)
-			continue
-		}
-
-		if q.Include != "" {
-			skip := true
-			includes := strings.Split(q.Include, ",")
-			for _, include := range includes {
-				if strings.Contains(asset.Name, include) {
-					skip = false
-				}
-			}
-			if skip {
-				continue
-			}
-		}
-
-		os := getOS(asset.Name)
-		arch := getArch(asset.Name)
-
-		if os == "" {
-			logger.Debug("fetched asset has unknown os: %s", asset.Name)
-			continue
-		}
-		if arch == "" {
-			continue
-		}
-
-		logger.Debug("fetched asset: %s", asset.Name)
-
-		asset.OS = os
-		asset.Arch = arch
-		asset.Type = fext
-
-		if !index[asset.Key()] {
-			index[asset.Key()] = true
-			filtered = append(filtered, asset)
+	if token != "" && q.Private {
+		for i := range assets {
+			assets[i].DownloadURL = assets[i].URL
 		}
 	}
+	filtered, matches := selectAssets(q, assets)
 
 	if len(filtered) == 0 {
-		return version, nil, errors.New("no downloads found for this release")
+		return version, nil, nil, errors.New("no downloads found for this release")
 	}
 
-	return version, filtered, nil
+	return version, filtered, matches, nil
 }
@@ -1,16 +1,98 @@
 package handler_test
 
 import (
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/aljabri00056/installer/handler"
+	"github.com/aljabri00056/installer/handler/provider"
+	"github.com/aljabri00056/installer/handler/provider/providertest"
 )
 
+// newForge returns a fake forge with a few well known releases
+func newForge(t *testing.T) *providertest.Server {
+	t.Helper()
+	f := providertest.NewServer()
+	t.Cleanup(f.Close)
+
+	micro := func(version string) providertest.Release {
+		bin := providertest.Program("micro", version)
+		dir := "micro-" + strings.TrimPrefix(version, "v") + "/"
+		archive := func(f providertest.File) providertest.File {
+			f.Name = dir + f.Name
+			return f
+		}
+		tgz := providertest.TarGz(archive(bin), archive(providertest.Text("LICENSE", "MIT")))
+		exe := bin
+		exe.Name = "micro.exe"
+		return providertest.Release{
+			Tag: version,
+			Assets: []providertest.Asset{
+				{Name: "micro-" + version + "-linux64.tar.gz", Data: tgz},
+				{Name: "micro-" + version + "-linux-arm64.tar.gz", Data: tgz},
+				{Name: "micro-" + version + "-osx.tar.gz", Data: tgz},
+				{Name: "micro-" + version + "-macos-arm64.tar.gz", Data: tgz},
+				{Name: "micro-" + version + "-freebsd64.tar.gz", Data: tgz},
+				{Name: "micro-" + version + "-win64.zip", Data: providertest.Zip(archive(exe))},
+				{Name: "micro-" + version + "-linux64.tar.gz.sha", Data: []byte("abc")},
+			},
+		}
+	}
+	f.AddRelease("zyedidia", "micro", micro("v2.0.12"))
+	f.AddRelease("zyedidia", "micro", micro("v2.0.13"))
+
+	gotty := func(version string) providertest.Release {
+		tgz := providertest.TarGz(providertest.Program("gotty", version))
+		return providertest.Release{
+			Tag: version,
+			Assets: []providertest.Asset{
+				{Name: "gotty_linux_amd64.tar.gz", Data: tgz},
+				{Name: "gotty_linux_arm.tar.gz", Data: tgz},
+				{Name: "gotty_darwin_amd64.tar.gz", Data: tgz},
+			},
+		}
+	}
+	f.AddRelease("yudai", "gotty", gotty("v0.0.12"))
+	f.AddRelease("yudai", "gotty", gotty("v0.0.13"))
+
+	aria2c := providertest.Program("aria2c", "1.37.0")
+	aria2c.Name = "aria2-1.37.0/" + aria2c.Name
+	aria2 := providertest.Zip(aria2c)
+	f.AddRelease("abcfy2", "aria2-static-build", providertest.Release{
+		Tag: "1.37.0",
+		Assets: []providertest.Asset{
+			{Name: "aria2-x86_64-linux-musl_static.zip", Data: aria2},
+			{Name: "aria2-aarch64-linux-musl_static.zip", Data: aria2},
+		},
+	})
+	return f
+}
+
+// newPrivateForge returns a fake forge which also holds the private
+// repository acme/secret
+func newPrivateForge(t *testing.T) *providertest.Server {
+	t.Helper()
+	f := newForge(t)
+	f.AddSecret("acme")
+	return f
+}
+
+func newHandler(f *providertest.Server) *handler.Handler {
+	return &handler.Handler{Config: handler.Config{
+		User:      "zyedidia",
+		GitHubAPI: f.GitHubAPI(),
+	}}
+}
+
 func TestJPilloraServe(t *testing.T) {
-	h := &handler.Handler{}
+	h := newHandler(newForge(t))
 	r := httptest.NewRequest("GET", "/abcfy2/aria2-static-build", nil)
 	w := httptest.NewRecorder()
 	h.ServeHTTP(w, r)
@@ -21,7 +103,7 @@ func TestJPilloraServe(t *testing.T) {
 }
 
 func TestMicro(t *testing.T) {
-	h := &handler.Handler{}
+	h := newHandler(newForge(t))
 	r := httptest.NewRequest("GET", "/micro", nil)
 	w := httptest.NewRecorder()
 	h.ServeHTTP(w, r)
@@ -29,21 +111,26 @@ func TestMicro(t *testing.T) {
 	if w.Result().StatusCode != 200 {
 		t.Fatalf("failed to get micro asset status")
 	}
+	if !strings.Contains(w.Body.String(), "micro-v2.0.13-linux64.tar.gz") {
+		t.Fatalf("expected latest micro release")
+	}
 }
 
 func TestMicroDoubleBang(t *testing.T) {
-	h := &handler.Handler{}
+	h := newHandler(newForge(t))
 	r := httptest.NewRequest("GET", "/micro!!", nil)
 	w := httptest.NewRecorder()
 	h.ServeHTTP(w, r)
 	t.Log(w.Body.String())
-	if w.Result().StatusCode != 200 {
-		t.Fatalf("failed to get micro!! asset status")
+	// the "!" suffix of upstream installer is not supported, the name is
+	// refused before any provider request
+	if w.Result().StatusCode != 400 {
+		t.Fatalf("expected micro!! to be refused, got %d", w.Result().StatusCode)
 	}
 }
 
 func TestGotty(t *testing.T) {
-	h := &handler.Handler{}
+	h := newHandler(newForge(t))
 	r := httptest.NewRequest("GET", "/yudai/gotty@v0.0.12", nil)
 	w := httptest.NewRecorder()
 	h.ServeHTTP(w, r)
@@ -51,44 +138,214 @@ func TestGotty(t *testing.T) {
 	if w.Result().StatusCode != 200 {
 		t.Fatalf("failed to get yudai/gotty status")
 	}
+	if !strings.Contains(w.Body.String(), "/v0.0.12/") {
+		t.Fatalf("expected gotty v0.0.12 assets")
+	}
 }
 
-func TestMicroInstall(t *testing.T) {
-	h := &handler.Handler{}
-	r := httptest.NewRequest("GET", "/micro?type=script", nil)
+func TestForgejo(t *testing.T) {
+	f := newForge(t)
+	h := &handler.Handler{Config: handler.Config{ProviderURL: f.URL}}
+	r := httptest.NewRequest("GET", "/forgejo/yudai/gotty", nil)
 	w := httptest.NewRecorder()
 	h.ServeHTTP(w, r)
 	if w.Result().StatusCode != 200 {
-		t.Fatalf("failed to get micro asset status")
-	}
-	// pipe into bash
-	bash := exec.Command("bash")
-	bash.Stdin = w.Body
-	bash.Dir = os.TempDir()
-	out, err := bash.CombinedOutput()
-	if err != nil {
-		t.Fatalf("failed to install micro: %s %s", err, out)
+		t.Fatalf("failed to get forgejo yudai/gotty status")
 	}
-	t.Log(string(out))
 }
 
-func TestMicroInstallAs(t *testing.T) {
-	h := &handler.Handler{}
-	r := httptest.NewRequest("GET", "/micro?type=script&as=mymicro", nil)
+func install(t *testing.T, h *handler.Handler, path string) string {
+	t.Helper()
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	r := httptest.NewRequest("GET", path, nil)
 	w := httptest.NewRecorder()
 	h.ServeHTTP(w, r)
 	if w.Result().StatusCode != 200 {
-		t.Fatalf("failed to get micro asset status")
+		t.Fatalf("failed to get script: %s", w.Body.String())
 	}
 	// pipe into bash
+	dir := t.TempDir()
 	bash := exec.Command("bash")
 	bash.Stdin = w.Body
-	bash.Dir = os.TempDir()
+	bash.Dir = dir
 	out, err := bash.CombinedOutput()
 	if err != nil {
-		t.Fatalf("failed to install micro as mymicro: %s %s", err, out)
+		t.Fatalf("failed to install: %s %s", err, out)
 	}
 	t.Log(string(out))
+	return dir
+}
+
+func run(t *testing.T, bin string) string {
+	t.Helper()
+	out, err := exec.Command(bin).CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to run %s: %s %s", bin, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestMicroInstall(t *testing.T) {
+	h := newHandler(newForge(t))
+	dir := install(t, h, "/micro?type=script&move=0")
+	if out := run(t, filepath.Join(dir, "micro")); out != "micro v2.0.13" {
+		t.Fatalf("unexpected micro output: %s", out)
+	}
+}
+
+func TestMicroInstallAs(t *testing.T) {
+	h := newHandler(newForge(t))
+	// as= picks binaries by partial name and installs them under it
+	dir := install(t, h, "/micro?type=script&move=0&as=mic")
+	if out := run(t, filepath.Join(dir, "mic")); out != "micro v2.0.13" {
+		t.Fatalf("unexpected mic output: %s", out)
+	}
+}
+
+func TestZipInstall(t *testing.T) {
+	h := newHandler(newForge(t))
+	dir := install(t, h, "/abcfy2/aria2-static-build?type=script&move=0&as=aria2c")
+	if _, err := os.Stat(filepath.Join(dir, "aria2c")); err != nil {
+		t.Fatalf("aria2c not installed: %s", err)
+	}
+}
+
+func TestLibcInstall(t *testing.T) {
+	f := newForge(t)
+	f.AddRelease("acme", "tool", providertest.Release{
+		Tag: "v1.0.0",
+		Assets: []providertest.Asset{
+			{Name: "tool-x86_64-unknown-linux-musl.tar.gz", Data: providertest.TarGz(providertest.Program("tool", "musl"))},
+			{Name: "tool-x86_64-unknown-linux-gnu.tar.gz", Data: providertest.TarGz(providertest.Program("tool", "gnu"))},
+		},
+	})
+	// the script installs the build for the C library of this host
+	want := "tool gnu"
+	if musl, _ := filepath.Glob("/lib/ld-musl-*"); len(musl) > 0 {
+		want = "tool musl"
+	}
+	dir := install(t, newHandler(f), "/acme/tool?type=script&move=0&arch=amd64")
+	if out := run(t, filepath.Join(dir, "tool")); out != want {
+		t.Fatalf("expected %q, got %q", want, out)
+	}
+}
+
+// compress pipes data through the command tool, skipping the test when
+// the host lacks it
+func compress(t *testing.T, tool string, data []byte) []byte {
+	t.Helper()
+	if _, err := exec.LookPath(tool); err != nil {
+		t.Skipf("%s not available", tool)
+	}
+	cmd := exec.Command(tool, "-c")
+	cmd.Stdin = strings.NewReader(string(data))
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("failed to compress with %s: %s", tool, err)
+	}
+	return out
+}
+
+func TestFileTypeInstall(t *testing.T) {
+	program := providertest.Program("tool", "v1.0.0")
+	tests := []struct {
+		name, tool string
+		tar        bool
+	}{
+		{"tool_linux_amd64.gz", "gzip", false},
+		{"tool_linux_amd64.tar.xz", "xz", true},
+		{"tool_linux_amd64.xz", "xz", false},
+		{"tool_linux_amd64.tar.zst", "zstd", true},
+		{"tool_linux_amd64.zst", "zstd", false},
+		{"tool_linux_amd64.tar.bz2", "bzip2", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data := program.Data
+			if tc.tar {
+				data = providertest.Tar(program)
+			}
+			f := newForge(t)
+			f.AddRelease("acme", "tool", providertest.Release{
+				Tag:    "v1.0.0",
+				Assets: []providertest.Asset{{Name: tc.name, Data: compress(t, tc.tool, data)}},
+			})
+			dir := install(t, newHandler(f), "/acme/tool?type=script&move=0&arch=amd64")
+			if out := run(t, filepath.Join(dir, "tool")); out != "tool v1.0.0" {
+				t.Fatalf("unexpected tool output: %s", out)
+			}
+		})
+	}
+}
+
+func TestArchFallback(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("needs a linux/amd64 host")
+	}
+	f := newForge(t)
+	f.AddRelease("acme", "tool", providertest.Release{
+		Tag: "v1.0.0",
+		Assets: []providertest.Asset{
+			{Name: "tool_linux_i686.tar.gz", Data: providertest.TarGz(providertest.Program("tool", "386"))},
+			{Name: "tool_linux_armv7.tar.gz", Data: providertest.TarGz(providertest.Program("tool", "armv7"))},
+		},
+	})
+	// without an amd64 build, 64-bit hosts take the 32-bit one
+	dir := install(t, newHandler(f), "/acme/tool?type=script&move=0")
+	if out := run(t, filepath.Join(dir, "tool")); out != "tool 386" {
+		t.Fatalf("expected the 386 build, got %q", out)
+	}
+	// ?arch= takes asset spellings and asks for exactly that build
+	dir = install(t, newHandler(f), "/acme/tool?type=script&move=0&arch=arm-v7")
+	if out := run(t, filepath.Join(dir, "tool")); out != "tool armv7" {
+		t.Fatalf("expected the armv7 build, got %q", out)
+	}
+}
+
+func TestPackages(t *testing.T) {
+	f := newForge(t)
+	deb := []byte("!<arch>\ndebian-binary")
+	f.AddRelease("acme", "tool", providertest.Release{
+		Tag: "v1.0.0",
+		Assets: []providertest.Asset{
+			{Name: "tool_linux_amd64.tar.gz", Data: providertest.TarGz(providertest.Program("tool", "tar.gz"))},
+			{Name: "tool_1.0.0_amd64.deb", Data: deb},
+			{Name: "tool-1.0.0-1.x86_64.rpm", Data: []byte("rpm")},
+			{Name: "Tool-1.0.0-x86_64.AppImage", Data: providertest.Program("tool", "AppImage").Data},
+		},
+	})
+	h := newHandler(f)
+	// packages are only offered when asked for
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/acme/tool", nil))
+	if body := w.Body.String(); strings.Contains(body, "(deb)") || !strings.Contains(body, "rejected tool_1.0.0_amd64.deb: deb package") {
+		t.Fatalf("expected packages to be left out: %s", body)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/acme/tool?pkg=auto", nil))
+	for _, key := range []string{"linux/amd64 (deb)", "linux/amd64 (rpm)", "linux/amd64 (appimage)", "linux/amd64\n"} {
+		if !strings.Contains(w.Body.String(), key) {
+			t.Fatalf("expected %s in %s", key, w.Body.String())
+		}
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/acme/tool?pkg=msi", nil))
+	if w.Result().StatusCode != 400 {
+		t.Fatalf("expected unknown package type to be refused, got %d", w.Result().StatusCode)
+	}
+
+	// AppImages are installed as the program
+	dir := install(t, h, "/acme/tool?type=script&move=0&arch=amd64&pkg=appimage")
+	if out := run(t, filepath.Join(dir, "tool")); out != "tool AppImage" {
+		t.Fatalf("expected the AppImage, got %q", out)
+	}
+	// packages are downloaded when not moving into PATH
+	dir = install(t, h, "/acme/tool?type=script&move=0&arch=amd64&pkg=deb")
+	if b, err := os.ReadFile(filepath.Join(dir, "tool_1.0.0_amd64.deb")); err != nil || string(b) != string(deb) {
+		t.Fatalf("expected the deb to be downloaded: %v", err)
+	}
 }
 
 func TestInvalidPath(t *testing.T) {
@@ -96,8 +353,8 @@ func TestInvalidPath(t *testing.T) {
 	r := httptest.NewRequest("GET", "/?type=script", nil)
 	w := httptest.NewRecorder()
 	h.ServeHTTP(w, r)
-	if w.Result().StatusCode != 302 {
-		t.Fatalf("expected redirect for empty path")
+	if w.Result().StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("expected redirect for empty path, got %d", w.Result().StatusCode)
 	}
 }
 
@@ -110,3 +367,521 @@ func TestInvalidProvider(t *testing.T) {
 		t.Fatalf("expected bad request for invalid provider")
 	}
 }
+
+func TestCache(t *testing.T) {
+	f := newForge(t)
+	h := newHandler(f)
+	get := func() {
+		r := httptest.NewRequest("GET", "/yudai/gotty", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Result().StatusCode != 200 {
+			t.Fatalf("failed to get yudai/gotty: %s", w.Body.String())
+		}
+	}
+	get()
+	before := f.Requests("yudai", "gotty")
+	get()
+	// only the repo lookup, the release comes from the cache
+	if n := f.Requests("yudai", "gotty") - before; n != 1 {
+		t.Fatalf("expected a single request to the forge, got %d", n)
+	}
+}
+
+func TestPrivateRepo(t *testing.T) {
+	f := newPrivateForge(t)
+	f.AddSecret("acme/tools")
+	tests := []struct {
+		name, owner, path string
+		config            handler.Config
+	}{
+		{"github", "acme", "/acme/secret", newHandler(f).Config},
+		{"ghe", "acme", "/corp/acme/secret", handler.Config{
+			Instances: []provider.Instance{{Name: "corp", Type: "ghe", URL: f.URL}},
+		}},
+		{"gitlab", "acme/tools", "/acme/tools/secret", handler.Config{Provider: "gitlab", ProviderURL: f.URL}},
+		{"gitlab instance", "acme", "/work/acme/secret", handler.Config{
+			Instances: []provider.Instance{{Name: "work", Type: "gitlab", URL: f.URL}},
+		}},
+		{"forgejo", "acme", "/git/acme/secret", handler.Config{
+			Instances: []provider.Instance{{Name: "git", Type: "forgejo", URL: f.URL}},
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &handler.Handler{Config: tc.config}
+			get := func(token, query string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest("GET", tc.path+"?type=script"+query, nil)
+				if token != "" {
+					r.Header.Set("Authorization", "Bearer "+token)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				return w
+			}
+			w := get(f.Token, "")
+			if w.Result().StatusCode != 200 {
+				t.Fatalf("failed to get private repo: %s", w.Body.String())
+			}
+			if strings.Contains(w.Body.String(), f.Token) {
+				t.Fatal("token leaked into the script")
+			}
+			// cached, but only for callers which can still see the repo
+			for _, token := range []string{"", "wrong"} {
+				if w := get(token, ""); w.Result().StatusCode != 400 {
+					t.Fatalf("expected token %q to be refused, got %d", token, w.Result().StatusCode)
+				}
+			}
+			// install options share the cached release
+			before := f.Requests(tc.owner, "secret")
+			if w := get(f.Token, "&as=other&move=0"); w.Result().StatusCode != 200 {
+				t.Fatalf("failed to get private repo: %s", w.Body.String())
+			}
+			if n := f.Requests(tc.owner, "secret") - before; n != 1 {
+				t.Fatalf("expected only the permission check, got %d requests", n)
+			}
+		})
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	f := newPrivateForge(t)
+	dir := t.TempDir()
+	get := func(path, token string) {
+		h := newHandler(f)
+		h.Cache = "file"
+		h.CacheDir = dir
+		r := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Result().StatusCode != 200 {
+			t.Fatalf("failed to get %s: %s", path, w.Body.String())
+		}
+	}
+	for _, tc := range []struct{ owner, repo, token string }{
+		{"yudai", "gotty", ""},
+		// private entries are keyed by token fingerprints, which
+		// survive the restart too
+		{"acme", "secret", f.Token},
+	} {
+		path := "/" + tc.owner + "/" + tc.repo
+		get(path, tc.token)
+		before := f.Requests(tc.owner, tc.repo)
+		// a restarted server is warm
+		get(path, tc.token)
+		if n := f.Requests(tc.owner, tc.repo) - before; n != 1 {
+			t.Fatalf("%s: expected a single request to the forge, got %d", path, n)
+		}
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	h := newHandler(newForge(t))
+	tests := []struct {
+		path, version string
+		code          int
+	}{
+		{"/zyedidia/micro@^2.0", "v2.0.13", 200},
+		{"/zyedidia/micro@2.x", "v2.0.13", 200},
+		{"/zyedidia/micro@%3E%3D2.0%20%3C2.0.13", "v2.0.12", 200},
+		{"/micro@~2.0.12", "v2.0.13", 200},
+		{"/micro@v2.0.12", "v2.0.12", 200},
+		{"/zyedidia/micro@^3", "", 502},
+		{"/zyedidia/micro@^^1", "", 400},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", tc.path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Result().StatusCode != tc.code {
+			t.Fatalf("%s: expected status %d, got %d: %s", tc.path, tc.code, w.Result().StatusCode, w.Body.String())
+		}
+		if tc.version != "" && !strings.Contains(w.Body.String(), "version: "+tc.version+"\n") {
+			t.Fatalf("%s: expected version %s: %s", tc.path, tc.version, w.Body.String())
+		}
+	}
+}
+
+func TestChannel(t *testing.T) {
+	f := newForge(t)
+	for _, rel := range []providertest.Release{
+		{Tag: "v2.1.0-rc1", Prerelease: true},
+		{Tag: "nightly", Name: "Nightly build", Prerelease: true},
+		{Tag: "v2.2.0", Draft: true},
+	} {
+		rel.Assets = []providertest.Asset{
+			{Name: "micro-" + rel.Tag + "-linux64.tar.gz", Data: providertest.TarGz(providertest.Program("micro", rel.Tag))},
+		}
+		f.AddRelease("zyedidia", "micro", rel)
+	}
+	h := newHandler(f)
+	tests := []struct {
+		path, version string
+		code          int
+	}{
+		{"/zyedidia/micro", "v2.0.13", 200},
+		{"/zyedidia/micro?channel=stable", "v2.0.13", 200},
+		{"/zyedidia/micro?channel=prerelease", "nightly", 200},
+		{"/zyedidia/micro@latest-pre", "nightly", 200},
+		{"/zyedidia/micro?channel=nightly", "nightly", 200},
+		{"/zyedidia/micro@^2?channel=prerelease", "v2.1.0-rc1", 200},
+		{"/zyedidia/micro@^2", "v2.0.13", 200},
+		{"/zyedidia/micro?channel=beta", "", 400},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", tc.path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Result().StatusCode != tc.code {
+			t.Fatalf("%s: expected status %d, got %d: %s", tc.path, tc.code, w.Result().StatusCode, w.Body.String())
+		}
+		if tc.version != "" && !strings.Contains(w.Body.String(), "version: "+tc.version+"\n") {
+			t.Fatalf("%s: expected version %s: %s", tc.path, tc.version, w.Body.String())
+		}
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	f := newForge(t)
+	// enough for the repository lookup only
+	f.RateLimit = 1
+	h := newHandler(f)
+	r := httptest.NewRequest("GET", "/zyedidia/micro", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Result().StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d: %s", w.Result().StatusCode, w.Body.String())
+	}
+	if after, err := strconv.Atoi(w.Result().Header.Get("Retry-After")); err != nil || after <= 0 {
+		t.Fatalf("expected Retry-After header, got %q", w.Result().Header.Get("Retry-After"))
+	}
+}
+
+func TestServerTokens(t *testing.T) {
+	f := newPrivateForge(t)
+	get := func(tokens map[string][]string) int {
+		h := newHandler(f)
+		h.Tokens = tokens
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/acme/secret", nil))
+		return w.Result().StatusCode
+	}
+	host := strings.TrimPrefix(f.URL, "http://")
+	tests := []struct {
+		tokens map[string][]string
+		code   int
+	}{
+		{nil, 400},
+		{map[string][]string{"github": {f.Token}}, 200},
+		{map[string][]string{"gitlab": {f.Token}}, 400},
+		{map[string][]string{"github": {"wrong"}, host: {f.Token}}, 200},
+		{map[string][]string{"github": {f.Token}, host: {"wrong"}}, 400},
+	}
+	for _, tc := range tests {
+		if code := get(tc.tokens); code != tc.code {
+			t.Fatalf("%v: expected status %d, got %d", tc.tokens, tc.code, code)
+		}
+	}
+	// the pools of a type stay with the instance named after it
+	h := newHandler(f)
+	h.Tokens = map[string][]string{"gitlab": {f.Token}, "forgejo": {f.Token}}
+	h.Instances = []provider.Instance{
+		{Name: "work", Type: "gitlab", URL: f.URL},
+		{Name: "git", Type: "forgejo", URL: f.URL},
+	}
+	for _, path := range []string{"/work/acme/secret", "/git/acme/secret"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Result().StatusCode != 400 {
+			t.Fatalf("%s: expected no token, got status %d", path, w.Result().StatusCode)
+		}
+	}
+}
+
+func TestInstances(t *testing.T) {
+	f := newPrivateForge(t)
+	h := newHandler(f)
+	h.Instances = []provider.Instance{
+		{Name: "work", Type: "gitlab", URL: f.URL, Tokens: []string{f.Token}},
+		{Name: "Git", Type: "forgejo", URL: f.URL},
+	}
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/work/yudai/gotty", 200},
+		{"/work/acme/secret", 200},
+		{"/git/yudai/gotty", 200},
+		{"/GIT/yudai/gotty", 200},
+		{"/git/acme/secret", 400},
+		// plain paths still belong to github
+		{"/yudai/gotty", 200},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Result().StatusCode != tc.code {
+			t.Fatalf("%s: expected status %d, got %d: %s", tc.path, tc.code, w.Result().StatusCode, w.Body.String())
+		}
+	}
+
+	// GitHub users named after the newer public forges keep their repos,
+	// until an instance of that name is configured
+	f.AddRelease("bitbucket", "gotty", providertest.Release{Tag: "v1.0.0", Assets: []providertest.Asset{
+		{Name: "gotty_linux_amd64.tar.gz", Data: providertest.TarGz(providertest.Program("gotty", "v1.0.0"))},
+	}})
+	f.AddRelease("ghcr", "gotty", providertest.Release{Tag: "v1.0.0", Assets: []providertest.Asset{
+		{Name: "gotty_linux_amd64.tar.gz", Data: providertest.TarGz(providertest.Program("gotty", "v1.0.0"))},
+	}})
+	h = newHandler(f)
+	for _, path := range []string{"/bitbucket/gotty", "/ghcr/gotty"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Result().StatusCode != 200 {
+			t.Fatalf("%s: expected the github user, got %d: %s", path, w.Result().StatusCode, w.Body.String())
+		}
+	}
+	h = newHandler(f)
+	h.Instances = []provider.Instance{{Name: "bitbucket", Type: "bitbucket", URL: f.URL, API: f.BitbucketAPI()}}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/bitbucket/gotty", nil))
+	if w.Result().StatusCode != 400 {
+		t.Fatalf("expected the configured bitbucket instance, got %d", w.Result().StatusCode)
+	}
+
+	h = newHandler(f)
+	h.Instances = []provider.Instance{{Name: "svn", Type: "subversion", URL: f.URL}}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/yudai/gotty", nil))
+	if w.Result().StatusCode != 500 {
+		t.Fatalf("expected misconfigured server, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestGitHubEnterprise(t *testing.T) {
+	f := newPrivateForge(t)
+	h := newHandler(f)
+	h.Instances = []provider.Instance{{Name: "corp", Type: "ghe", URL: f.URL, Tokens: []string{f.Token}}}
+	host := strings.TrimPrefix(f.URL, "http://")
+	for _, path := range []string{"/corp/yudai/gotty", "/" + host + "/yudai/gotty", "/corp/acme/secret"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Result().StatusCode != 200 {
+			t.Fatalf("%s: expected status 200, got %d: %s", path, w.Result().StatusCode, w.Body.String())
+		}
+	}
+	// the script downloads private assets through the enterprise API
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_ENTERPRISE_TOKEN", f.Token)
+	dir := install(t, h, "/"+host+"/acme/secret?type=script&move=0")
+	if out := run(t, filepath.Join(dir, "secret")); out != "secret v1.0.0" {
+		t.Fatalf("unexpected secret output: %s", out)
+	}
+}
+
+func TestGitLab(t *testing.T) {
+	f := newPrivateForge(t)
+	f.AddSecret("acme/tools")
+	// a self-hosted GitLab as the default provider
+	h := &handler.Handler{Config: handler.Config{
+		Provider:    "gitlab",
+		ProviderURL: f.URL,
+		Tokens:      map[string][]string{"gitlab": {f.Token}},
+	}}
+	// the script downloads from the package registry itself
+	t.Setenv("GITLAB_TOKEN", f.Token)
+	dir := install(t, h, "/acme/tools/secret?type=script&move=0")
+	if out := run(t, filepath.Join(dir, "secret")); out != "secret v1.0.0" {
+		t.Fatalf("unexpected secret output: %s", out)
+	}
+	// job tokens are passed on as such
+	h = &handler.Handler{Config: handler.Config{Provider: "gitlab", ProviderURL: f.URL}}
+	r := httptest.NewRequest("GET", "/acme/tools/secret", nil)
+	r.Header.Set("Job-Token", f.Token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Result().StatusCode != 200 {
+		t.Fatalf("failed to get with job token: %s", w.Body.String())
+	}
+	for _, path := range []string{"/acme//secret", "/acme/../secret", "/acme/tools/"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Result().StatusCode != 400 {
+			t.Fatalf("%s: expected status 400, got %d", path, w.Result().StatusCode)
+		}
+	}
+}
+
+func TestBitbucket(t *testing.T) {
+	f := newForge(t)
+	f.Token = providertest.SecretToken
+	f.AddRepo("team", "tool", true)
+	f.AddRelease("team", "tool", providertest.Release{
+		Tag: "1.4.0",
+		Assets: []providertest.Asset{
+			{Name: "tool-1.4.0-linux-amd64.tar.gz", Data: providertest.TarGz(providertest.Program("tool", "1.4.0"))},
+		},
+	})
+	h := &handler.Handler{Config: handler.Config{
+		Instances: []provider.Instance{{Name: "bitbucket", Type: "bitbucket", URL: "https://bitbucket.org", API: f.BitbucketAPI()}},
+		Tokens:    map[string][]string{"bitbucket": {"someone:" + f.Token}},
+	}}
+	t.Setenv("BITBUCKET_USERNAME", "someone")
+	t.Setenv("BITBUCKET_APP_PASSWORD", f.Token)
+	dir := install(t, h, "/bitbucket/team/tool?type=script&move=0")
+	if out := run(t, filepath.Join(dir, "tool")); out != "tool 1.4.0" {
+		t.Fatalf("unexpected tool output: %s", out)
+	}
+}
+
+func TestSourceHut(t *testing.T) {
+	f := newForge(t)
+	h := &handler.Handler{Config: handler.Config{
+		Instances: []provider.Instance{{Name: "srht", Type: "srht", URL: f.URL}},
+	}}
+	for path, want := range map[string]string{
+		"/srht/~yudai/gotty?type=script&move=0":        "gotty v0.0.13",
+		"/srht/yudai/gotty@v0.0.12?type=script&move=0": "gotty v0.0.12",
+	} {
+		dir := install(t, h, path)
+		if out := run(t, filepath.Join(dir, "gotty")); out != want {
+			t.Fatalf("%s: unexpected gotty output: %s", path, out)
+		}
+	}
+}
+
+func TestHTTPFiles(t *testing.T) {
+	f := newForge(t)
+	for _, version := range []string{"1.2.0", "1.3.0", "1.3.1", "1.4.0"} {
+		f.AddRelease("", "ourtool", providertest.Release{
+			Tag: version,
+			Assets: []providertest.Asset{
+				{Name: "ourtool_" + version + "_linux_amd64.tar.gz", Data: providertest.TarGz(providertest.Program("ourtool", version))},
+				{Name: "ourtool_" + version + "_linux_arm64.tar.gz", Data: providertest.TarGz(providertest.Program("ourtool", version))},
+				{Name: "ourtool_" + version + "_darwin_arm64.tar.gz", Data: providertest.TarGz(providertest.Program("ourtool", version))},
+			},
+		})
+	}
+	h := &handler.Handler{Config: handler.Config{
+		Instances: []provider.Instance{{Name: "files", Type: "http", URL: f.FilesURL()}},
+	}}
+	for path, want := range map[string]string{
+		"/files/ourtool?type=script&move=0":       "ourtool 1.4.0",
+		"/files/ourtool@1.3?type=script&move=0":   "ourtool 1.3.1",
+		"/files/ourtool@~1.2?type=script&move=0":  "ourtool 1.2.0",
+		"/files/ourtool@1.3.0?type=script&move=0": "ourtool 1.3.0",
+	} {
+		dir := install(t, h, path)
+		if out := run(t, filepath.Join(dir, "ourtool")); out != want {
+			t.Fatalf("%s: unexpected ourtool output: %s", path, out)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/files/someone/ourtool", nil))
+	if w.Code == http.StatusOK {
+		t.Fatalf("expected tools with users to fail: %s", w.Body)
+	}
+}
+
+func TestS3(t *testing.T) {
+	f := newForge(t)
+	f.Token = "AKID"
+	f.AddRepo("", "ourtool", true)
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		f.AddRelease("", "ourtool", providertest.Release{
+			Tag: version,
+			Assets: []providertest.Asset{
+				{Name: "ourtool_linux_amd64.tar.gz", Data: providertest.TarGz(providertest.Program("ourtool", version))},
+				{Name: "ourtool_linux_arm64.tar.gz", Data: providertest.TarGz(providertest.Program("ourtool", version))},
+			},
+		})
+	}
+	h := &handler.Handler{Config: handler.Config{
+		Instances: []provider.Instance{{Name: "nightly", Type: "s3", URL: f.S3URL()}},
+		Tokens:    map[string][]string{"nightly": {"AKID:secret-key"}},
+	}}
+	for path, want := range map[string]string{
+		"/nightly/ourtool?type=script&move=0":       "ourtool 1.1.0",
+		"/nightly/ourtool@1.0.0?type=script&move=0": "ourtool 1.0.0",
+	} {
+		dir := install(t, h, path)
+		if out := run(t, filepath.Join(dir, "ourtool")); out != want {
+			t.Fatalf("%s: unexpected ourtool output: %s", path, out)
+		}
+	}
+	// presigned URLs name the access key, never the secret
+	for _, path := range []string{"/nightly/ourtool?type=script", "/nightly/ourtool"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if body := w.Body.String(); !strings.Contains(body, "X-Amz-Credential=AKID") || strings.Contains(body, "secret-key") {
+			t.Fatalf("%s: expected presigned URLs without the secret: %s", path, body)
+		}
+	}
+	// and session tokens would be in the URL
+	h = &handler.Handler{Config: handler.Config{
+		Instances: []provider.Instance{{Name: "nightly", Type: "s3", URL: f.S3URL()}},
+		Tokens:    map[string][]string{"nightly": {"AKID:secret-key:session-token"}},
+	}}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/nightly/ourtool", nil))
+	if body := w.Body.String(); w.Result().StatusCode == 200 || strings.Contains(body, "session-token") || strings.Contains(body, "secret-key") {
+		t.Fatalf("expected session credentials to be refused: %d %s", w.Result().StatusCode, body)
+	}
+	// role credentials from the environment are left alone, public tools
+	// still install with plain URLs
+	f.AddRelease("", "pubtool", providertest.Release{Tag: "1.0.0", Assets: []providertest.Asset{
+		{Name: "pubtool_linux_amd64.tar.gz", Data: providertest.TarGz(providertest.Program("pubtool", "1.0.0"))},
+	}})
+	t.Setenv("AWS_ACCESS_KEY_ID", "ASIAROLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "role-secret")
+	t.Setenv("AWS_SESSION_TOKEN", "role-session")
+	config := handler.GetConfigFromEnv()
+	if _, ok := config.Tokens["s3"]; ok {
+		t.Fatal("expected session credentials to stay out of the s3 pool")
+	}
+	config.Instances = []provider.Instance{{Name: "nightly", Type: "s3", URL: f.S3URL()}}
+	config.Tokens["nightly"] = []string{"ASIAROLE:role-secret:role-session"}
+	h = &handler.Handler{Config: config}
+	dir := install(t, h, "/nightly/pubtool?type=script&move=0")
+	if out := run(t, filepath.Join(dir, "pubtool")); out != "pubtool 1.0.0" {
+		t.Fatalf("unexpected pubtool output: %s", out)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/nightly/pubtool", nil))
+	if body := w.Body.String(); strings.Contains(body, "X-Amz-") || strings.Contains(body, "ASIAROLE") {
+		t.Fatalf("expected plain URLs for a public tool: %s", body)
+	}
+}
+
+func TestOCI(t *testing.T) {
+	f := newForge(t)
+	f.Token = providertest.SecretToken
+	f.AddRepo("acme", "tool", true)
+	f.AddRelease("acme", "tool", providertest.Release{Tag: "v1.0.0", Assets: []providertest.Asset{
+		{Name: "tool_linux_amd64.tar.gz", Data: providertest.TarGz(providertest.Program("tool", "v1.0.0"))},
+		{Name: "tool_linux_arm64.tar.gz", Data: providertest.TarGz(providertest.Program("tool", "v1.0.0"))},
+	}})
+	bin := providertest.Program("tool", "v1.1.0").Data
+	f.AddRelease("acme", "tool", providertest.Release{Tag: "v1.1.0", Assets: []providertest.Asset{
+		{Name: "tool", Data: bin, Platform: "linux/amd64"},
+		{Name: "tool", Data: bin, Platform: "linux/arm64"},
+	}})
+	h := &handler.Handler{Config: handler.Config{
+		Instances: []provider.Instance{{Name: "registry", Type: "oci", URL: f.RegistryURL()}},
+		Tokens:    map[string][]string{"registry": {"someone:" + f.Token}},
+	}}
+	t.Setenv("OCI_USERNAME", "someone")
+	t.Setenv("OCI_PASSWORD", f.Token)
+	for path, want := range map[string]string{
+		"/registry/acme/tool?type=script&move=0&as=tool": "tool v1.1.0",
+		"/registry/acme/tool@v1.0.0?type=script&move=0":  "tool v1.0.0",
+	} {
+		dir := install(t, h, path)
+		if out := run(t, filepath.Join(dir, "tool")); out != want {
+			t.Fatalf("%s: unexpected tool output: %s", path, out)
+		}
+	}
+}
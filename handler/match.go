@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aljabri00056/installer/handler/provider"
+	"github.com/aljabri00056/installer/logger"
+)
+
+var (
+	// checksumRe matches checksum, signature and certificate files
+	checksumRe = regexp.MustCompile(`(?i)((^|[._-])(checksums?|sha\d*sums?|md5sums?)([._-]|$)|\.(sha\d+|md5|asc|sig|minisig|pem|crt|sbom)$)`)
+	debugRe    = regexp.MustCompile(`(?i)(^|[._-])(debug|dbg|debuginfo|symbols|dsym|pdb)([._-]|$)`)
+	versionRe  = regexp.MustCompile(`^(v?\d+|rc\d*|alpha\d*|beta\d*)$`)
+	wordSepRe  = regexp.MustCompile(`[-_.+ ]+`)
+)
+
+// nameWords are the parts of asset names which say nothing about what is
+// in them besides the platform, such as the rest of target triples
+var nameWords = map[string]bool{
+	"musl": true, "gnu": true, "glibc": true, "static": true, "unknown": true, "pc": true,
+	"apple": true, "msvc": true, "eabi": true, "eabihf": true, "gnueabi": true, "gnueabihf": true,
+	"musleabi": true, "musleabihf": true, "hf": true, "x86": true, "os": true, "universal": true,
+	"bin": true, "debug": true, "dbg": true, "debuginfo": true, "symbols": true, "dsym": true, "pdb": true,
+}
+
+// Match records how an asset of the release fared in asset selection
+type Match struct {
+	Name string
+	// Key is the platform of the asset, empty when it was rejected before
+	// the platform was known
+	Key     string
+	Score   int
+	Chosen  bool
+	Reasons []string
+}
+
+// String describes the match for the text output and the logs
+func (m Match) String() string {
+	switch {
+	case m.Chosen:
+		return fmt.Sprintf("chosen %s for %s (score %d): %s", m.Name, m.Key, m.Score, strings.Join(m.Reasons, ", "))
+	case m.Key != "":
+		return fmt.Sprintf("skipped %s for %s (score %d): %s", m.Name, m.Key, m.Score, strings.Join(m.Reasons, ", "))
+	default:
+		return fmt.Sprintf("rejected %s: %s", m.Name, strings.Join(m.Reasons, ", "))
+	}
+}
+
+// selectAssets picks the best asset for each platform of the release. Of
+// several assets for one platform the highest score wins, ties go to the
+// one listed first. Every asset gets a Match saying why it was chosen or
+// not. Chosen assets keep the order of their platforms in the release.
+func selectAssets(q Query, assets []provider.Asset) ([]provider.Asset, []Match) {
+	matches := make([]Match, len(assets))
+	candidates := map[int]provider.Asset{}
+	best := map[string]int{}
+	keys := []string{}
+	for i, asset := range assets {
+		m := &matches[i]
+		m.Name = asset.Name
+		reject := func(format string, args ...any) {
+			m.Reasons = append(m.Reasons, fmt.Sprintf(format, args...))
+			logger.Debug("%s", m)
+		}
+		if !isDownloadURL(asset.DownloadURL) {
+			reject("invalid download url %q", asset.DownloadURL)
+			continue
+		}
+		if checksumRe.MatchString(asset.Name) {
+			reject("checksum or signature")
+			continue
+		}
+		fext := getFileExt(asset.Name)
+		if fext == "" && (asset.Size > 1024*1024 || asset.OS != "") {
+			fext = ".bin" // +1MB binary, or one the provider knows the platform of
+		}
+		ftype, ok := getFileType(fext)
+		if !ok {
+			reject("unsupported file type %q", fext)
+			continue
+		}
+		asset.Type = fext
+		pkg := asset.Package()
+		switch {
+		case pkg != "" && q.Pkg != "auto" && q.Pkg != pkg:
+			reject("%s package, ask for it with ?pkg=%s", pkg, pkg)
+			continue
+		case pkg == "apk" && strings.Contains(strings.ToLower(asset.Name), "android"):
+			reject("android app")
+			continue
+		}
+		if q.Include != "" && !includes(asset.Name, q.Include) {
+			reject("does not match %q", q.Include)
+			continue
+		}
+		// platforms known to the provider win over guesses from the name
+		if asset.OS == "" {
+			asset.OS = getOS(asset.Name)
+		}
+		// packages rarely name the OS they are for, nor do .exe files
+		if asset.OS == "" && pkg != "" {
+			asset.OS = "linux"
+		}
+		if asset.OS == "" {
+			asset.OS = ftype.OS
+		}
+		if asset.Arch == "" {
+			asset.Arch = getArch(asset.Name)
+		}
+		if asset.OS == "" {
+			reject("unknown os")
+			continue
+		}
+		if asset.Arch == "" {
+			reject("unknown arch")
+			continue
+		}
+		if ftype.OS != "" && asset.OS != ftype.OS {
+			reject("%s files are for %s only", fext, ftype.OS)
+			continue
+		}
+		// builds for each C library are kept, the script picks one
+		if asset.OS == "linux" && asset.Libc == "" && pkg == "" {
+			asset.Libc = getLibc(asset.Name)
+		}
+		m.Key = asset.Key()
+		m.Score, m.Reasons = scoreAsset(q.Program, asset, ftype)
+		candidates[i] = asset
+		if j, ok := best[m.Key]; !ok {
+			best[m.Key] = i
+			keys = append(keys, m.Key)
+		} else if m.Score > matches[j].Score {
+			best[m.Key] = i
+		}
+	}
+	chosen := []provider.Asset{}
+	for _, key := range keys {
+		chosen = append(chosen, candidates[best[key]])
+		matches[best[key]].Chosen = true
+	}
+	for i := range matches {
+		m := &matches[i]
+		if m.Key == "" {
+			continue
+		}
+		if !m.Chosen {
+			m.Reasons = append(m.Reasons, "outscored by "+matches[best[m.Key]].Name)
+		}
+		logger.Debug("%s", m)
+	}
+	return chosen, matches
+}
+
+// includes reports whether name contains any of the comma separated
+// include filters
+func includes(name, include string) bool {
+	for _, s := range strings.Split(include, ",") {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// scoreAsset rates how well a supported asset of a known platform suits
+// program, with the reasons for the score
+func scoreAsset(program string, a provider.Asset, t FileType) (int, []string) {
+	score := t.Score
+	reasons := []string{fmt.Sprintf("%s %+d", strings.TrimPrefix(a.Type, "."), score)}
+	add := func(n int, reason string) {
+		score += n
+		reasons = append(reasons, fmt.Sprintf("%s %+d", reason, n))
+	}
+	name := strings.TrimSuffix(strings.ToLower(a.Name), a.Type)
+	program = strings.ToLower(program)
+	if debugRe.MatchString(name) {
+		add(-10, "debug build")
+	}
+	switch {
+	case program == "":
+	case strings.HasPrefix(name, program):
+		add(2, "named after program")
+	case strings.Contains(name, program):
+		add(1, "mentions program")
+	}
+	// words which are neither the program, the platform nor the version
+	// hint at a different flavour or companion tool, as in tool-server
+	rest := name
+	if program != "" {
+		rest = strings.ReplaceAll(rest, program, " ")
+	}
+	rest = archRe.ReplaceAllString(rest, " ")
+	rest = posixOSRe.ReplaceAllString(rest, " ")
+	extra := []string{}
+	for _, w := range wordSepRe.Split(rest, -1) {
+		if len(w) > 1 && !nameWords[w] && !versionRe.MatchString(w) {
+			extra = append(extra, w)
+		}
+	}
+	if len(extra) > 0 {
+		add(-len(extra), "extra words "+strings.Join(extra, " "))
+	}
+	return score, reasons
+}
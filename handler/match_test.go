@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aljabri00056/installer/handler/provider"
+)
+
+func TestSelectAssets(t *testing.T) {
+	tests := []struct {
+		program string
+		assets  []string
+		chosen  map[string]string
+	}{
+		{"tool", []string{
+			"tool-linux-amd64.deb",
+			"tool-linux-amd64.tar.gz",
+			"tool-linux-amd64-musl.tar.gz",
+		}, map[string]string{
+			"linux/amd64":      "tool-linux-amd64.tar.gz",
+			"linux/amd64/musl": "tool-linux-amd64-musl.tar.gz",
+		}},
+		{"ripgrep", []string{
+			"ripgrep-14.1.0-x86_64-unknown-linux-gnu.tar.gz",
+			"ripgrep-14.1.0-x86_64-unknown-linux-gnu.tar.gz.sha256",
+			"ripgrep-14.1.0-x86_64-unknown-linux-musl.tar.gz",
+			"ripgrep-14.1.0-x86_64-pc-windows-msvc.zip",
+			"ripgrep-14.1.0-aarch64-apple-darwin.tar.gz",
+		}, map[string]string{
+			"linux/amd64/gnu":  "ripgrep-14.1.0-x86_64-unknown-linux-gnu.tar.gz",
+			"linux/amd64/musl": "ripgrep-14.1.0-x86_64-unknown-linux-musl.tar.gz",
+			"windows/amd64":    "ripgrep-14.1.0-x86_64-pc-windows-msvc.zip",
+			"darwin/arm64":     "ripgrep-14.1.0-aarch64-apple-darwin.tar.gz",
+		}},
+		{"tool", []string{
+			"tool-debug_1.0.0_linux_amd64.tar.gz",
+			"tool_1.0.0_linux_amd64.tar.gz",
+		}, map[string]string{"linux/amd64": "tool_1.0.0_linux_amd64.tar.gz"}},
+		{"tool", []string{
+			"tool-server_linux_arm64.tar.gz",
+			"tool_linux_arm64.tar.gz",
+			"tool_linux_arm64.gz",
+		}, map[string]string{"linux/arm64": "tool_linux_arm64.tar.gz"}},
+		{"gotty", []string{
+			"checksums.txt",
+			"gotty_linux_amd64.tar.gz.asc",
+			"gotty_darwin_amd64.tar.gz",
+		}, map[string]string{"darwin/amd64": "gotty_darwin_amd64.tar.gz"}},
+	}
+	for _, tc := range tests {
+		assets := []provider.Asset{}
+		for _, name := range tc.assets {
+			assets = append(assets, provider.Asset{Name: name, DownloadURL: "https://example.com/" + name})
+		}
+		chosen, matches := selectAssets(Query{Program: tc.program}, assets)
+		got := map[string]string{}
+		for _, a := range chosen {
+			got[a.Key()] = a.Name
+		}
+		if len(got) != len(tc.chosen) {
+			t.Fatalf("%s: expected %v, got %v", tc.program, tc.chosen, got)
+		}
+		for key, name := range tc.chosen {
+			if got[key] != name {
+				t.Fatalf("%s: expected %s for %s, got %s", tc.program, name, key, got[key])
+			}
+		}
+		if len(matches) != len(tc.assets) {
+			t.Fatalf("%s: expected a match per asset, got %d", tc.program, len(matches))
+		}
+		for _, m := range matches {
+			if len(m.Reasons) == 0 || m.Chosen != (got[m.Key] == m.Name) {
+				t.Fatalf("%s: unexpected match %s", tc.program, m)
+			}
+		}
+	}
+}
+
+func TestSelectAssetsReasons(t *testing.T) {
+	_, matches := selectAssets(Query{Program: "tool"}, []provider.Asset{
+		{Name: "tool_linux_amd64.tar.gz.sha256", DownloadURL: "https://example.com/a"},
+		{Name: "tool_linux_amd64.deb", DownloadURL: "https://example.com/b"},
+		{Name: "tool_linux_amd64.tar.gz", DownloadURL: "ftp://example.com/c"},
+		{Name: "tool.tar.gz", DownloadURL: "https://example.com/d"},
+		{Name: "tool_linux_amd64.zip", DownloadURL: "https://example.com/e"},
+		{Name: "tool_linux_amd64.gz", DownloadURL: "https://example.com/f"},
+		{Name: "tool_darwin_amd64.pkg", DownloadURL: "https://example.com/g"},
+	})
+	want := []string{
+		"rejected tool_linux_amd64.tar.gz.sha256: checksum or signature",
+		"rejected tool_linux_amd64.deb: deb package, ask for it with ?pkg=deb",
+		`rejected tool_linux_amd64.tar.gz: invalid download url "ftp://example.com/c"`,
+		"rejected tool.tar.gz: unknown os",
+		"chosen tool_linux_amd64.zip for linux/amd64 (score 4)",
+		"skipped tool_linux_amd64.gz for linux/amd64 (score 3)",
+		`rejected tool_darwin_amd64.pkg: unsupported file type ".pkg"`,
+	}
+	for i, m := range matches {
+		if !strings.HasPrefix(m.String(), want[i]) {
+			t.Fatalf("expected %q, got %q", want[i], m)
+		}
+	}
+	if s := matches[5].String(); !strings.HasSuffix(s, "outscored by tool_linux_amd64.zip") {
+		t.Fatalf("expected the winner to be named, got %q", s)
+	}
+}
@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"time"
+)
+
+// Bitbucket serves the downloads of a Bitbucket repository as releases.
+// Downloads are grouped by the version in their file names.
+type Bitbucket struct {
+	BaseProvider
+	BaseURL string
+	// Server is set for Bitbucket Server and Data Center. They have no
+	// downloads section, the files in the downloads directory of the
+	// default branch are used instead.
+	Server bool
+}
+
+type bbDownload struct {
+	Name      string    `json:"name"`
+	Size      int       `json:"size"`
+	CreatedOn time.Time `json:"created_on"`
+	Links     struct {
+		Self struct {
+			Href string `json:"href"`
+		} `json:"self"`
+	} `json:"links"`
+}
+
+type bbDownloads struct {
+	Values []bbDownload `json:"values"`
+	Next   string       `json:"next"`
+}
+
+type bbServerFiles struct {
+	Children struct {
+		Values []struct {
+			Path struct {
+				Name string `json:"name"`
+			} `json:"path"`
+			Size int    `json:"size"`
+			Type string `json:"type"`
+		} `json:"values"`
+		IsLastPage    bool `json:"isLastPage"`
+		NextPageStart int  `json:"nextPageStart"`
+	} `json:"children"`
+}
+
+func (b *Bitbucket) repoURL(user, repo string) string {
+	if b.Server {
+		return fmt.Sprintf("%s/projects/%s/repos/%s", b.BaseURL, neturl.PathEscape(user), neturl.PathEscape(repo))
+	}
+	return fmt.Sprintf("%s/repositories/%s/%s", b.BaseURL, neturl.PathEscape(user), neturl.PathEscape(repo))
+}
+
+func (b *Bitbucket) GetRepo(ctx context.Context, user, repo, token string) (*RepoInfo, error) {
+	if user == "" || repo == "" {
+		return nil, fmt.Errorf("user and repo are required")
+	}
+	var res struct {
+		IsPrivate bool `json:"is_private"`
+		// Public is used by Bitbucket Server
+		Public bool `json:"public"`
+	}
+	if err := b.get(ctx, b.repoURL(user, repo), token, &res); err != nil {
+		return nil, fmt.Errorf("failed to get repo info: %w", err)
+	}
+	if b.Server {
+		return &RepoInfo{Private: !res.Public}, nil
+	}
+	return &RepoInfo{Private: res.IsPrivate}, nil
+}
+
+func (b *Bitbucket) GetReleaseAssets(ctx context.Context, user, repo, release, token string) (string, []Asset, error) {
+	releases, err := b.ListReleases(ctx, user, repo, token)
+	if err != nil {
+		return "", nil, err
+	}
+	r, err := findRelease(releases, release)
+	if err != nil {
+		return "", nil, err
+	}
+	return r.Tag, r.Assets, nil
+}
+
+func (b *Bitbucket) ListReleases(ctx context.Context, user, repo, token string) ([]Release, error) {
+	files, err := b.downloads(ctx, user, repo, token)
+	if err != nil {
+		return nil, err
+	}
+	return filesToReleases(files), nil
+}
+
+func (b *Bitbucket) downloads(ctx context.Context, user, repo, token string) ([]file, error) {
+	files := []file{}
+	if b.Server {
+		start := 0
+		for page := 0; page < maxPages; page++ {
+			var resp bbServerFiles
+			url := fmt.Sprintf("%s/browse/downloads?limit=1000&start=%d", b.repoURL(user, repo), start)
+			if err := b.get(ctx, url, token, &resp); err != nil {
+				return nil, err
+			}
+			for _, f := range resp.Children.Values {
+				if f.Type != "FILE" {
+					continue
+				}
+				u := fmt.Sprintf("%s/raw/downloads/%s", b.repoURL(user, repo), neturl.PathEscape(f.Path.Name))
+				files = append(files, file{Asset: Asset{Name: f.Path.Name, Size: f.Size, URL: u, DownloadURL: u}})
+			}
+			if resp.Children.IsLastPage {
+				break
+			}
+			start = resp.Children.NextPageStart
+		}
+		return files, nil
+	}
+	url := b.repoURL(user, repo) + "/downloads?pagelen=100"
+	for page := 0; url != "" && page < maxPages; page++ {
+		var resp bbDownloads
+		if err := b.get(ctx, url, token, &resp); err != nil {
+			return nil, err
+		}
+		for _, d := range resp.Values {
+			u := d.Links.Self.Href
+			files = append(files, file{Asset: Asset{Name: d.Name, Size: d.Size, URL: u, DownloadURL: u}, Modified: d.CreatedOn})
+		}
+		url = resp.Next
+	}
+	return files, nil
+}
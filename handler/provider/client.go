@@ -0,0 +1,107 @@
+package provider
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultTimeout bounds every forge API call made by a client
+// built by NewHTTPClient
+const DefaultTimeout = 30 * time.Second
+
+// defaultClient is shared by providers created without options
+var defaultClient = NewHTTPClient()
+
+// Option configures the HTTP client used by a provider
+type Option func(*options)
+
+type options struct {
+	client    *http.Client
+	timeout   time.Duration
+	transport http.RoundTripper
+	proxy     *url.URL
+	rootCAs   *x509.CertPool
+	onLimit   func(token string, rl RateLimit)
+}
+
+// WithHTTPClient makes the provider use c as is. It takes precedence over
+// all other options, so that one client can be shared by many providers.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) { o.client = c }
+}
+
+// WithTimeout sets the overall timeout of each request
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) { o.timeout = d }
+}
+
+// WithTransport replaces the default transport. Proxy and CA options are
+// ignored when a transport is given.
+func WithTransport(t http.RoundTripper) Option {
+	return func(o *options) { o.transport = t }
+}
+
+// WithProxy sends all requests through the proxy at u instead of the one
+// configured in the environment
+func WithProxy(u *url.URL) Option {
+	return func(o *options) { o.proxy = u }
+}
+
+// WithRootCAs verifies servers against pool instead of the system roots
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(o *options) { o.rootCAs = pool }
+}
+
+// WithRateLimitHook calls fn with the rate limit the forge reports for
+// each request, e.g. TokenPool.Observe
+func WithRateLimitHook(fn func(token string, rl RateLimit)) Option {
+	return func(o *options) { o.onLimit = fn }
+}
+
+// NewHTTPClient builds the client described by opts. It revalidates
+// responses it has seen before instead of fetching them again.
+func NewHTTPClient(opts ...Option) *http.Client {
+	o := options{timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o.httpClient()
+}
+
+func (o *options) httpClient() *http.Client {
+	if o.client != nil {
+		return o.client
+	}
+	transport := o.transport
+	if transport == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		if o.proxy != nil {
+			t.Proxy = http.ProxyURL(o.proxy)
+		}
+		if o.rootCAs != nil {
+			t.TLSClientConfig = &tls.Config{RootCAs: o.rootCAs}
+		}
+		transport = t
+	}
+	return &http.Client{Transport: newConditionalTransport(transport), Timeout: o.timeout}
+}
+
+// custom reports whether the options ask for a client of its own
+func (o *options) custom() bool {
+	return o.client != nil || o.timeout != DefaultTimeout || o.transport != nil || o.proxy != nil || o.rootCAs != nil
+}
+
+func newBaseProvider(opts []Option) BaseProvider {
+	o := options{timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	client := defaultClient
+	if o.custom() {
+		client = o.httpClient()
+	}
+	return BaseProvider{Client: client, onLimit: o.onLimit}
+}
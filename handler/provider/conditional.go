@@ -0,0 +1,134 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aljabri00056/installer/handler/cache"
+)
+
+const (
+	// conditionalSize is the number of responses kept for revalidation
+	conditionalSize = 1000
+	// conditionalMaxBody is the largest response body kept
+	conditionalMaxBody = 1 << 20
+	// conditionalMaxBytes is the most bytes of bodies kept in total
+	conditionalMaxBytes = 32 << 20
+)
+
+// validated is a response which can be revalidated with its validators
+type validated struct {
+	ETag         string
+	LastModified string
+	Header       http.Header
+	Body         []byte
+}
+
+// conditionalTransport remembers the ETag and Last-Modified of API
+// responses and revalidates them with If-None-Match/If-Modified-Since.
+// A 304 is answered with the stored body. GitHub does not count 304s
+// against the rate limit.
+type conditionalTransport struct {
+	next  http.RoundTripper
+	store *cache.Memory[validated]
+}
+
+func newConditionalTransport(next http.RoundTripper) *conditionalTransport {
+	store := cache.NewWeightedMemory[validated](conditionalSize, conditionalMaxBytes, func(v validated) int64 {
+		return int64(len(v.Body))
+	})
+	return &conditionalTransport{next: next, store: store}
+}
+
+// perRequestKey marks the contexts of requests whose credentials change
+// with every request, such as the bearer tokens of OCI registries
+type perRequestKey struct{}
+
+func withPerRequestAuth(ctx context.Context) context.Context {
+	return context.WithValue(ctx, perRequestKey{}, true)
+}
+
+// perRequestAuth reports whether the credentials of req change with every
+// request, as SigV4 signatures do. Responses to it would be stored under
+// a key which never comes again.
+func perRequestAuth(req *http.Request) bool {
+	if req.Context().Value(perRequestKey{}) != nil {
+		return true
+	}
+	return strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256") || req.URL.Query().Has("X-Amz-Signature")
+}
+
+// key identifies the response to req, responses differ per credential
+func (t *conditionalTransport) key(req *http.Request) string {
+	h := sha256.New()
+	io.WriteString(h, req.URL.String())
+	for _, name := range []string{"Authorization", "Private-Token", "Job-Token"} {
+		io.WriteString(h, "\x00"+req.Header.Get(name))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" || perRequestAuth(req) {
+		return t.next.RoundTrip(req)
+	}
+	key := t.key(req)
+	stored, ok := t.store.Get(key)
+	if ok {
+		req = req.Clone(req.Context())
+		if v := stored.Value.ETag; v != "" {
+			req.Header.Set("If-None-Match", v)
+		}
+		if v := stored.Value.LastModified; v != "" {
+			req.Header.Set("If-Modified-Since", v)
+		}
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case ok && resp.StatusCode == http.StatusNotModified:
+		resp.Body.Close()
+		header := stored.Value.Header.Clone()
+		// keep the fresh rate limit
+		for name, values := range resp.Header {
+			if strings.HasPrefix(name, "X-Ratelimit-") {
+				header[name] = values
+			}
+		}
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		resp.Header = header
+		resp.ContentLength = int64(len(stored.Value.Body))
+		resp.Body = io.NopCloser(bytes.NewReader(stored.Value.Body))
+	case resp.StatusCode == http.StatusOK && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""):
+		body, err := io.ReadAll(io.LimitReader(resp.Body, conditionalMaxBody+1))
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if len(body) > conditionalMaxBody {
+			// too large to keep, pass it on as it is
+			resp.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+			return resp, nil
+		}
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		t.store.Set(key, cache.Entry[validated]{Value: validated{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Header:       resp.Header.Clone(),
+			Body:         body,
+		}})
+	}
+	return resp, nil
+}
@@ -2,35 +2,135 @@ package provider
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
 )
 
 const (
-	DefaultGitHubAPI   = "https://api.github.com"
-	DefaultCodebergAPI = "https://codeberg.org/api/v1"
-	DefaultGitLabAPI   = "https://gitlab.com/api/v4"
+	DefaultGitHubAPI    = "https://api.github.com"
+	DefaultCodebergAPI  = "https://codeberg.org/api/v1"
+	DefaultGitLabAPI    = "https://gitlab.com/api/v4"
+	DefaultBitbucketAPI = "https://api.bitbucket.org/2.0"
+	DefaultSourceHutAPI = "https://git.sr.ht/query"
 )
 
-// NewProvider creates a new provider instance based on the provider type
-func NewProvider(providerType string, baseURL string) (Provider, error) {
+// NewProvider creates a new provider instance based on the provider type.
+// For github, baseURL is the API URL and defaults to DefaultGitHubAPI.
+// Without options, providers share a default client with DefaultTimeout.
+func NewProvider(providerType string, baseURL string, opts ...Option) (Provider, error) {
 	providerType = strings.ToLower(strings.TrimSpace(providerType))
-	
+	inst := Instance{Name: providerType, Type: providerType, URL: baseURL}
 	switch providerType {
 	case "github", "":
-		return &GitHub{BaseURL: DefaultGitHubAPI}, nil
-	case "forgejo":
-		if baseURL == "" {
-			return nil, fmt.Errorf("baseURL is required for Forgejo provider")
-		}
-		return &GitHub{BaseURL: fmt.Sprintf("%s/api/v1", strings.TrimSuffix(baseURL, "/"))}, nil
+		inst = Instance{Name: "github", Type: "github", URL: "https://github.com", API: baseURL}
 	case "codeberg":
-		return &GitHub{BaseURL: DefaultCodebergAPI}, nil
+		inst = Instance{Name: "codeberg", Type: "forgejo", URL: "https://codeberg.org", API: DefaultCodebergAPI}
 	case "gitlab":
 		if baseURL == "" {
-			return &GitLab{BaseURL: DefaultGitLabAPI}, nil
+			inst.URL = "https://gitlab.com"
+		}
+	case "bitbucket":
+		if baseURL == "" {
+			inst.URL = "https://bitbucket.org"
+		}
+	case "srht":
+		if baseURL == "" {
+			inst.URL = "https://git.sr.ht"
+		}
+	}
+	return inst.NewProvider(opts...)
+}
+
+// NewProvider creates a provider for the instance
+func (i Instance) NewProvider(opts ...Option) (Provider, error) {
+	api, err := i.APIURL()
+	if err != nil {
+		return nil, err
+	}
+	base := newBaseProvider(opts)
+	switch i.Type {
+	case "github", "ghe", "forgejo":
+		return &GitHub{BaseProvider: base, BaseURL: api}, nil
+	case "gitlab":
+		base.auth = gitlabAuth
+		return &GitLab{BaseProvider: base, BaseURL: api}, nil
+	case "bitbucket":
+		base.auth = basicOrBearerAuth
+		return &Bitbucket{BaseProvider: base, BaseURL: api, Server: strings.Contains(api, "/rest/api/")}, nil
+	case "srht":
+		base.auth = srhtAuth
+		return &SourceHut{BaseProvider: base, BaseURL: api}, nil
+	case "http":
+		base.auth = basicOrBearerAuth
+		return &HTTPFiles{BaseProvider: base, BaseURL: api}, nil
+	case "s3":
+		return newS3(base, api)
+	case "oci":
+		return newOCI(base, api), nil
+	}
+	return nil, fmt.Errorf("unsupported provider type: %s (supported: %s)", i.Type, strings.Join(instanceTypes, ", "))
+}
+
+// APIURL returns the base URL of the instance's API
+func (i Instance) APIURL() (string, error) {
+	if i.API != "" {
+		return strings.TrimSuffix(i.API, "/"), nil
+	}
+	switch i.Type {
+	case "github", "ghe":
+		if i.Type == "github" && (i.URL == "" || i.Host() == "github.com") {
+			return DefaultGitHubAPI, nil
+		}
+		if i.URL == "" {
+			return "", fmt.Errorf("URL is required for GitHub Enterprise instance %s", i.Name)
+		}
+		// GitHub Enterprise Server
+		return strings.TrimSuffix(i.URL, "/") + "/api/v3", nil
+	case "forgejo":
+		if i.URL == "" {
+			return "", fmt.Errorf("baseURL is required for Forgejo provider")
+		}
+		return strings.TrimSuffix(i.URL, "/") + "/api/v1", nil
+	case "gitlab":
+		if i.URL == "" {
+			return DefaultGitLabAPI, nil
+		}
+		return strings.TrimSuffix(i.URL, "/") + "/api/v4", nil
+	case "bitbucket":
+		if i.URL == "" || i.Host() == "bitbucket.org" {
+			return DefaultBitbucketAPI, nil
 		}
-		return &GitLab{BaseURL: fmt.Sprintf("%s/api/v4", strings.TrimSuffix(baseURL, "/"))}, nil
-	default:
-		return nil, fmt.Errorf("unsupported provider type: %s (supported: github, gitlab, codeberg, forgejo)", providerType)
+		// Bitbucket Server and Data Center
+		return strings.TrimSuffix(i.URL, "/") + "/rest/api/1.0", nil
+	case "srht":
+		if i.URL == "" {
+			return DefaultSourceHutAPI, nil
+		}
+		return strings.TrimSuffix(i.URL, "/") + "/query", nil
+	case "http":
+		if i.URL == "" {
+			return "", fmt.Errorf("URL is required for file server instance %s", i.Name)
+		}
+		return strings.TrimSuffix(i.URL, "/"), nil
+	case "s3":
+		if i.URL == "" {
+			return "", fmt.Errorf("bucket URL is required for S3 instance %s", i.Name)
+		}
+		return i.URL, nil
+	case "oci":
+		if i.URL == "" {
+			return "", fmt.Errorf("URL is required for registry instance %s", i.Name)
+		}
+		return strings.TrimSuffix(i.URL, "/"), nil
+	}
+	return "", fmt.Errorf("unsupported provider type: %s (supported: %s)", i.Type, strings.Join(instanceTypes, ", "))
+}
+
+// Host returns the lower case host of the instance's web URL
+func (i Instance) Host() string {
+	u, err := url.Parse(i.URL)
+	if err != nil {
+		return ""
 	}
+	return strings.ToLower(u.Host)
 }
@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/aljabri00056/installer/handler/semver"
+)
+
+// fileVersionRe finds the version in the name of a file, as in
+// tool-1.2.3-linux-amd64.tar.gz or tool_v2.0.0-rc1_windows.zip
+var fileVersionRe = regexp.MustCompile(`(?i)(?:^|[^0-9a-z])(v?\d+\.\d+(?:\.\d+)?(?:-(?:alpha|beta|rc|pre)\.?\d*)?)(?:[^0-9]|$)`)
+
+// file is a downloadable file of a forge without releases
+type file struct {
+	Asset
+	Modified time.Time
+}
+
+// fileVersion returns the version in the name of a file, or ""
+func fileVersion(name string) string {
+	m := fileVersionRe.FindStringSubmatch(name)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// filesToReleases groups files into releases by the version in their
+// names, highest version first. Files without a version, as in
+// tool_linux_amd64.tar.gz, are the release "latest" when no file has a
+// version, and are left out otherwise.
+func filesToReleases(files []file) []Release {
+	type group struct {
+		v   semver.Version
+		rel Release
+	}
+	groups := map[string]*group{}
+	unversioned := Release{Tag: "latest", Name: "latest"}
+	for _, f := range files {
+		tag := fileVersion(f.Name)
+		v, err := semver.Parse(tag)
+		if err != nil {
+			unversioned.Assets = append(unversioned.Assets, f.Asset)
+			if f.Modified.After(unversioned.Published) {
+				unversioned.Created = f.Modified
+				unversioned.Published = f.Modified
+			}
+			continue
+		}
+		g, ok := groups[v.String()]
+		if !ok {
+			g = &group{v: v, rel: Release{Tag: tag, Name: tag, Prerelease: v.IsPrerelease()}}
+			groups[v.String()] = g
+		}
+		g.rel.Assets = append(g.rel.Assets, f.Asset)
+		if f.Modified.After(g.rel.Published) {
+			g.rel.Created = f.Modified
+			g.rel.Published = f.Modified
+		}
+	}
+	if len(groups) == 0 && len(unversioned.Assets) > 0 {
+		return []Release{unversioned}
+	}
+	sorted := []*group{}
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].v.Compare(sorted[j].v) > 0
+	})
+	releases := []Release{}
+	for _, g := range sorted {
+		releases = append(releases, g.rel)
+	}
+	return releases
+}
+
+// sortReleases sorts releases by version, highest first. Tags which are
+// not semantic versions go last, newest first.
+func sortReleases(releases []Release) {
+	sort.SliceStable(releases, func(i, j int) bool {
+		vi, erri := semver.Parse(releases[i].Tag)
+		vj, errj := semver.Parse(releases[j].Tag)
+		switch {
+		case erri == nil && errj == nil:
+			return vi.Compare(vj) > 0
+		case erri == nil || errj == nil:
+			return erri == nil
+		}
+		return releases[i].Published.After(releases[j].Published)
+	})
+}
+
+// findRelease picks the newest stable release for "latest", otherwise the
+// release with the given tag or version. Releases must be sorted highest
+// first, a partial version like 1.3 then picks the newest 1.3.x.
+func findRelease(releases []Release, release string) (Release, error) {
+	if release == "" || release == "latest" {
+		for _, r := range releases {
+			if !r.Prerelease && !r.Draft {
+				return r, nil
+			}
+		}
+		return Release{}, fmt.Errorf("no releases found")
+	}
+	for _, r := range releases {
+		if r.Tag == release {
+			return r, nil
+		}
+	}
+	if c, err := semver.ParseConstraint(release); err == nil {
+		for _, r := range releases {
+			if v, err := semver.Parse(r.Tag); err == nil && !r.Draft && c.Check(v) {
+				return r, nil
+			}
+		}
+	}
+	return Release{}, fmt.Errorf("release not found: %s", release)
+}
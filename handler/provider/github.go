@@ -1,7 +1,9 @@
 package provider
 
 import (
+	"context"
 	"fmt"
+	"time"
 )
 
 type GitHub struct {
@@ -18,30 +20,34 @@ type ghAsset struct {
 }
 
 type ghRelease struct {
-	Assets  []ghAsset `json:"assets"`
-	Name    string    `json:"name"`
-	TagName string    `json:"tag_name"`
-	URL     string    `json:"url"`
+	Assets      []ghAsset `json:"assets"`
+	Name        string    `json:"name"`
+	TagName     string    `json:"tag_name"`
+	URL         string    `json:"url"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	CreatedAt   time.Time `json:"created_at"`
+	PublishedAt time.Time `json:"published_at"`
 }
 
 type ghRepo struct {
 	Private bool `json:"private"`
 }
 
-func (g *GitHub) GetRepo(user, repo, token string) (*RepoInfo, error) {
+func (g *GitHub) GetRepo(ctx context.Context, user, repo, token string) (*RepoInfo, error) {
 	if user == "" || repo == "" {
 		return nil, fmt.Errorf("user and repo are required")
 	}
-	
+
 	url := fmt.Sprintf(g.BaseURL+"/repos/%s/%s", user, repo)
 	var res ghRepo
-	if err := g.get(url, token, &res); err != nil {
+	if err := g.get(ctx, url, token, &res); err != nil {
 		return nil, fmt.Errorf("failed to get repo info: %w", err)
 	}
 	return &RepoInfo{Private: res.Private}, nil
 }
 
-func (g *GitHub) GetReleaseAssets(user, repo, release, token string) (string, []Asset, error) {
+func (g *GitHub) GetReleaseAssets(ctx context.Context, user, repo, release, token string) (string, []Asset, error) {
 	var assets []Asset
 	var version string
 
@@ -50,34 +56,58 @@ func (g *GitHub) GetReleaseAssets(user, repo, release, token string) (string, []
 	if release == "" || release == "latest" {
 		url += "/latest"
 		var resp ghRelease
-		if err := g.get(url, token, &resp); err != nil {
+		if err := g.get(ctx, url, token, &resp); err != nil {
 			return "", nil, err
 		}
 		version = resp.TagName
-		for _, a := range resp.Assets {
-			assets = append(assets, Asset{
-				Name:        a.Name,
-				URL:         a.URL,
-				Size:        a.Size,
-				DownloadURL: a.BrowserDownloadURL,
-			})
-		}
+		assets = resp.assets()
 	} else {
 		version = release
 		url = fmt.Sprintf(g.BaseURL+"/repos/%s/%s/releases/tags/%s", user, repo, release)
 		var resp ghRelease
-		if err := g.get(url, token, &resp); err != nil {
+		if err := g.get(ctx, url, token, &resp); err != nil {
 			return "", nil, err
 		}
-		for _, a := range resp.Assets {
-			assets = append(assets, Asset{
-				Name:        a.Name,
-				URL:         a.URL,
-				Size:        a.Size,
-				DownloadURL: a.BrowserDownloadURL,
+		assets = resp.assets()
+	}
+
+	return version, assets, nil
+}
+
+func (g *GitHub) ListReleases(ctx context.Context, user, repo, token string) ([]Release, error) {
+	url := fmt.Sprintf(g.BaseURL+"/repos/%s/%s/releases?per_page=100", user, repo)
+	releases := []Release{}
+	for page := 0; url != "" && page < maxPages; page++ {
+		var resp []ghRelease
+		next, err := g.getPage(ctx, url, token, &resp)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range resp {
+			releases = append(releases, Release{
+				Tag:        r.TagName,
+				Name:       r.Name,
+				Prerelease: r.Prerelease,
+				Draft:      r.Draft,
+				Created:    r.CreatedAt,
+				Published:  r.PublishedAt,
+				Assets:     r.assets(),
 			})
 		}
+		url = next
 	}
+	return releases, nil
+}
 
-	return version, assets, nil
+func (r ghRelease) assets() []Asset {
+	assets := []Asset{}
+	for _, a := range r.Assets {
+		assets = append(assets, Asset{
+			Name:        a.Name,
+			URL:         a.URL,
+			Size:        a.Size,
+			DownloadURL: a.BrowserDownloadURL,
+		})
+	}
+	return assets
 }
@@ -1,7 +1,14 @@
 package provider
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/aljabri00056/installer/handler/semver"
 )
 
 type GitLab struct {
@@ -10,77 +17,151 @@ type GitLab struct {
 }
 
 type glAsset struct {
-	URL  string `json:"url"`
-	Name string `json:"name"`
-	Size int    `json:"size"`
+	URL            string `json:"url"`
+	DirectAssetURL string `json:"direct_asset_url"`
+	Name           string `json:"name"`
+	LinkType       string `json:"link_type"`
 }
 
 type glRelease struct {
-	Name        string    `json:"name"`
-	TagName     string    `json:"tag_name"`
-	Assets      struct {
+	Name            string    `json:"name"`
+	TagName         string    `json:"tag_name"`
+	UpcomingRelease bool      `json:"upcoming_release"`
+	CreatedAt       time.Time `json:"created_at"`
+	ReleasedAt      time.Time `json:"released_at"`
+	Assets          struct {
 		Links []glAsset `json:"links"`
 	} `json:"assets"`
 }
 
+// prerelease reports whether r is a pre-release. GitLab has no such flag,
+// so it is inferred from the tag.
+func (r glRelease) prerelease() bool {
+	v, err := semver.Parse(r.TagName)
+	return err == nil && v.IsPrerelease()
+}
+
 type glRepo struct {
-	Private bool `json:"visibility"`
+	// Visibility is one of public, internal or private
+	Visibility string `json:"visibility"`
 }
 
-func (g *GitLab) GetRepo(user, repo, token string) (*RepoInfo, error) {
+// projectID is the URL encoded path of a project, user may be a nested
+// namespace such as group/subgroup
+func projectID(user, repo string) string {
+	return neturl.PathEscape(user + "/" + repo)
+}
+
+// gitlabAuth sends job tokens as JOB-TOKEN and all others as PRIVATE-TOKEN
+func gitlabAuth(req *http.Request, token string) {
+	if job, ok := strings.CutPrefix(token, JobTokenPrefix); ok {
+		req.Header.Set("JOB-TOKEN", job)
+		return
+	}
+	if strings.HasPrefix(token, "glcbt-") {
+		req.Header.Set("JOB-TOKEN", token)
+		return
+	}
+	req.Header.Set("PRIVATE-TOKEN", token)
+}
+
+func (g *GitLab) GetRepo(ctx context.Context, user, repo, token string) (*RepoInfo, error) {
 	if user == "" || repo == "" {
 		return nil, fmt.Errorf("user and repo are required")
 	}
 
-	url := fmt.Sprintf("%s/projects/%s%%2F%s", g.BaseURL, user, repo)
+	url := fmt.Sprintf("%s/projects/%s", g.BaseURL, projectID(user, repo))
 	var res glRepo
-	if err := g.get(url, token, &res); err != nil {
+	if err := g.get(ctx, url, token, &res); err != nil {
 		return nil, fmt.Errorf("failed to get repo info: %w", err)
 	}
-	return &RepoInfo{Private: res.Private}, nil
+	// anonymous callers get the basic view without the visibility, they
+	// can only see public projects. Internal projects need a token too.
+	return &RepoInfo{Private: token != "" && res.Visibility != "" && res.Visibility != "public"}, nil
 }
 
-func (g *GitLab) GetReleaseAssets(user, repo, release, token string) (string, []Asset, error) {
+func (g *GitLab) GetReleaseAssets(ctx context.Context, user, repo, release, token string) (string, []Asset, error) {
 	var assets []Asset
 	var version string
 
-	url := fmt.Sprintf("%s/projects/%s%%2F%s/releases", g.BaseURL, user, repo)
-	
 	if release == "" || release == "latest" {
-		var releases []glRelease
-		if err := g.get(url, token, &releases); err != nil {
-			return "", nil, err
+		// the newest published, stable release, which may be pages down
+		// behind upcoming releases and pre-releases
+		url := fmt.Sprintf("%s/projects/%s/releases?per_page=100", g.BaseURL, projectID(user, repo))
+		found := false
+		for page := 0; url != "" && !found && page < maxPages; page++ {
+			var releases []glRelease
+			next, err := g.getPage(ctx, url, token, &releases)
+			if err != nil {
+				return "", nil, err
+			}
+			for _, r := range releases {
+				if r.UpcomingRelease || r.prerelease() {
+					continue
+				}
+				version = r.TagName
+				assets = r.assets()
+				found = true
+				break
+			}
+			url = next
 		}
-		if len(releases) == 0 {
+		if !found {
 			return "", nil, fmt.Errorf("no releases found")
 		}
-		
-		version = releases[0].TagName
-		for _, a := range releases[0].Assets.Links {
-			assets = append(assets, Asset{
-				Name:        a.Name,
-				URL:         a.URL,
-				Size:        a.Size,
-				DownloadURL: a.URL,
-			})
-		}
 	} else {
 		version = release
-		url = fmt.Sprintf("%s/projects/%s%%2F%s/releases/%s", g.BaseURL, user, repo, release)
+		url := fmt.Sprintf("%s/projects/%s/releases/%s", g.BaseURL, projectID(user, repo), neturl.PathEscape(release))
 		var resp glRelease
-		if err := g.get(url, token, &resp); err != nil {
+		if err := g.get(ctx, url, token, &resp); err != nil {
 			return "", nil, err
 		}
-		
-		for _, a := range resp.Assets.Links {
-			assets = append(assets, Asset{
-				Name:        a.Name,
-				URL:         a.URL,
-				Size:        a.Size,
-				DownloadURL: a.URL,
+		assets = resp.assets()
+	}
+
+	return version, assets, nil
+}
+
+func (g *GitLab) ListReleases(ctx context.Context, user, repo, token string) ([]Release, error) {
+	url := fmt.Sprintf("%s/projects/%s/releases?per_page=100", g.BaseURL, projectID(user, repo))
+	releases := []Release{}
+	for page := 0; url != "" && page < maxPages; page++ {
+		var resp []glRelease
+		next, err := g.getPage(ctx, url, token, &resp)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range resp {
+			releases = append(releases, Release{
+				Tag:        r.TagName,
+				Name:       r.Name,
+				Prerelease: r.prerelease(),
+				Draft:      r.UpcomingRelease,
+				Created:    r.CreatedAt,
+				Published:  r.ReleasedAt,
+				Assets:     r.assets(),
 			})
 		}
+		url = next
 	}
+	return releases, nil
+}
 
-	return version, assets, nil
+// assets lists the release links. Links into the generic package registry
+// are API URLs which accept a token, other links are downloaded through
+// their direct asset URL.
+func (r glRelease) assets() []Asset {
+	assets := []Asset{}
+	for _, a := range r.Assets.Links {
+		download := a.DirectAssetURL
+		if download == "" || strings.Contains(a.URL, "/packages/generic/") {
+			download = a.URL
+		}
+		assets = append(assets, Asset{
+			Name:        a.Name,
+			URL:         a.URL,
+			DownloadURL: download,
+		})
+	}
+	return assets
 }
@@ -0,0 +1,196 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aljabri00056/installer/handler/semver"
+)
+
+// HTTPFiles reads releases from a plain HTTP file server. Each tool lives
+// in <BaseURL>/<tool>/, described either by an index.json there or by the
+// server's HTML directory listing (nginx autoindex, Apache, S3 static
+// website listings). A listing may hold a directory per version or files
+// with the version in their names.
+type HTTPFiles struct {
+	BaseProvider
+	// BaseURL is the directory holding the tools
+	BaseURL string
+}
+
+// httpIndex is the index.json of a tool:
+//
+//	{"versions": {"1.3.0": [{"name": "tool_linux_amd64.tar.gz", "url": "1.3.0/tool_linux_amd64.tar.gz"}]}}
+//
+// Asset URLs are relative to the index and default to <version>/<name>.
+type httpIndex struct {
+	Versions map[string][]struct {
+		Name      string    `json:"name"`
+		URL       string    `json:"url"`
+		Size      int       `json:"size"`
+		Published time.Time `json:"published"`
+	} `json:"versions"`
+}
+
+// hrefRe finds the links of a directory listing
+var hrefRe = regexp.MustCompile(`(?i)<a\s[^>]*?href\s*=\s*["']([^"']+)["']`)
+
+func (h *HTTPFiles) dir(tool string) string {
+	return h.BaseURL + "/" + neturl.PathEscape(tool) + "/"
+}
+
+// GetRepo probes the tool anonymously, only a tool denied without the
+// token is private. Files which need the token need it for downloads too.
+func (h *HTTPFiles) GetRepo(ctx context.Context, user, repo, token string) (*RepoInfo, error) {
+	if repo == "" {
+		return nil, fmt.Errorf("tool is required")
+	}
+	if user != "" {
+		return nil, fmt.Errorf("file servers have no users, use /<instance>/%s", repo)
+	}
+	err := h.probe(ctx, repo, "")
+	switch {
+	case err == nil:
+		return &RepoInfo{}, nil
+	case token == "" || !errors.Is(err, errDenied):
+		return nil, fmt.Errorf("failed to get repo info: %w", err)
+	}
+	if err := h.probe(ctx, repo, token); err != nil {
+		return nil, fmt.Errorf("failed to get repo info: %w", err)
+	}
+	return &RepoInfo{Private: true}, nil
+}
+
+// probe requests the index.json of a tool, or its directory on servers
+// without one, leaving the body unread
+func (h *HTTPFiles) probe(ctx context.Context, tool, token string) error {
+	err := h.get(ctx, h.dir(tool)+"index.json", token, nil)
+	if errors.Is(err, errNotFound) {
+		err = h.get(ctx, h.dir(tool), token, nil)
+	}
+	return err
+}
+
+func (h *HTTPFiles) GetReleaseAssets(ctx context.Context, user, repo, release, token string) (string, []Asset, error) {
+	releases, err := h.ListReleases(ctx, user, repo, token)
+	if err != nil {
+		return "", nil, err
+	}
+	r, err := findRelease(releases, release)
+	if err != nil {
+		return "", nil, err
+	}
+	if r.Assets == nil {
+		// a version directory, listed on demand
+		files, _, err := h.listing(ctx, h.dir(repo)+neturl.PathEscape(r.Tag)+"/", token)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, f := range files {
+			r.Assets = append(r.Assets, f.Asset)
+		}
+	}
+	return r.Tag, r.Assets, nil
+}
+
+// ListReleases returns the versions of a tool, highest first. Releases of
+// version directories come without assets, GetReleaseAssets lists them.
+func (h *HTTPFiles) ListReleases(ctx context.Context, user, repo, token string) ([]Release, error) {
+	releases, err := h.index(ctx, repo, token)
+	if err == nil || !errors.Is(err, errNotFound) {
+		return releases, err
+	}
+	files, dirs, err := h.listing(ctx, h.dir(repo), token)
+	if err != nil {
+		return nil, err
+	}
+	releases = filesToReleases(files)
+	for _, d := range dirs {
+		v, err := semver.Parse(d)
+		if err != nil {
+			continue
+		}
+		releases = append(releases, Release{Tag: d, Name: d, Prerelease: v.IsPrerelease()})
+	}
+	sortReleases(releases)
+	return releases, nil
+}
+
+func (h *HTTPFiles) index(ctx context.Context, tool, token string) ([]Release, error) {
+	url := h.dir(tool) + "index.json"
+	var index httpIndex
+	if err := h.get(ctx, url, token, &index); err != nil {
+		return nil, err
+	}
+	base, err := neturl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	releases := []Release{}
+	for version, assets := range index.Versions {
+		v, err := semver.Parse(version)
+		r := Release{Tag: version, Name: version, Prerelease: err == nil && v.IsPrerelease(), Assets: []Asset{}}
+		for _, a := range assets {
+			ref := a.URL
+			if ref == "" {
+				ref = neturl.PathEscape(version) + "/" + neturl.PathEscape(a.Name)
+			}
+			u, err := base.Parse(ref)
+			if err != nil || a.Name == "" {
+				return nil, fmt.Errorf("invalid asset in %s: %q", url, a.Name)
+			}
+			r.Assets = append(r.Assets, Asset{Name: a.Name, Size: a.Size, URL: u.String(), DownloadURL: u.String()})
+			if a.Published.After(r.Published) {
+				r.Created, r.Published = a.Published, a.Published
+			}
+		}
+		releases = append(releases, r)
+	}
+	sortReleases(releases)
+	return releases, nil
+}
+
+// listing reads the HTML directory listing at url and returns the files
+// and the names of the subdirectories in it. Links leaving the directory,
+// such as the parent and sorting links, are ignored.
+func (h *HTTPFiles) listing(ctx context.Context, url, token string) ([]file, []string, error) {
+	var body []byte
+	if err := h.get(ctx, url, token, &body); err != nil {
+		return nil, nil, err
+	}
+	base, err := neturl.Parse(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := []file{}
+	dirs := []string{}
+	seen := map[string]bool{}
+	for _, m := range hrefRe.FindAllStringSubmatch(string(body), -1) {
+		href := html.UnescapeString(m[1])
+		if strings.ContainsAny(href, "?#") {
+			continue
+		}
+		u, err := base.Parse(href)
+		if err != nil || u.Host != base.Host || !strings.HasPrefix(u.EscapedPath(), base.EscapedPath()) {
+			continue
+		}
+		rest := strings.TrimPrefix(u.EscapedPath(), base.EscapedPath())
+		name, err := neturl.PathUnescape(strings.TrimSuffix(rest, "/"))
+		if err != nil || name == "" || strings.Contains(name, "/") || seen[rest] {
+			continue
+		}
+		seen[rest] = true
+		if strings.HasSuffix(rest, "/") {
+			dirs = append(dirs, name)
+			continue
+		}
+		files = append(files, file{Asset: Asset{Name: name, URL: u.String(), DownloadURL: u.String()}})
+	}
+	return files, dirs, nil
+}
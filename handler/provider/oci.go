@@ -0,0 +1,316 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"strings"
+
+	"github.com/aljabri00056/installer/handler/semver"
+)
+
+// media types of the manifests an OCI provider understands
+const (
+	ociIndexType       = "application/vnd.oci.image.index.v1+json"
+	ociManifestType    = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerManifestType = "application/vnd.docker.distribution.manifest.v2+json"
+	ociTitleAnnotation = "org.opencontainers.image.title"
+	ociUnknownPlatform = "unknown"
+)
+
+var (
+	// ociSignatureTagRe matches the tags cosign stores signatures,
+	// attestations and SBOMs under
+	ociSignatureTagRe = regexp.MustCompile(`^sha256-[0-9a-f]{64}\.(?:sig|att|sbom)$`)
+	challengeParamRe  = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// OCI reads binaries pushed as OCI artifacts, e.g. with oras push, from
+// a container registry. Each tag is a release. The layers of its manifest
+// are the assets, named by their org.opencontainers.image.title
+// annotation, the manifests of an image index add their platform. Tokens
+// are "username:password", a bare token is used as the password.
+type OCI struct {
+	BaseProvider
+	// BaseURL is the registry, e.g. https://ghcr.io
+	BaseURL string
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int               `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant"`
+	} `json:"platform"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+func newOCI(base BaseProvider, baseURL string) *OCI {
+	base.header = http.Header{"Accept": {strings.Join([]string{ociIndexType, ociManifestType, dockerManifestList, dockerManifestType}, ", ")}}
+	base.auth = func(req *http.Request, token string) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	// every lookup gets a bearer token of its own
+	base.perRequestAuth = true
+	return &OCI{BaseProvider: base, BaseURL: baseURL}
+}
+
+// ociBasicAuth sends the credentials of a token request
+func ociBasicAuth(req *http.Request, token string) {
+	if !strings.Contains(token, ":") {
+		token = "token:" + token
+	}
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(token)))
+}
+
+func (o *OCI) name(user, repo string) string {
+	if user == "" {
+		return repo
+	}
+	return user + "/" + repo
+}
+
+// authorize asks the registry how to authenticate and, when it wants a
+// bearer token, gets one for pulling name. Registries without
+// authentication return no token and no token URL.
+func (o *OCI) authorize(ctx context.Context, name, token string) (bearer, tokenURL string, err error) {
+	tokenURL, err = o.challenge(ctx, name)
+	if err != nil || tokenURL == "" {
+		return "", tokenURL, err
+	}
+	bearer, err = o.grant(ctx, tokenURL, token)
+	return bearer, tokenURL, err
+}
+
+// challenge returns the URL of the bearer token for pulling name, or ""
+// when the registry wants no authentication
+func (o *OCI) challenge(ctx context.Context, name string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", o.BaseURL+"/v2/", nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid request: %s: %s", o.BaseURL, err)
+	}
+	client := o.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %s: %s", o.BaseURL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		return "", nil
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	params := map[string]string{}
+	for _, m := range challengeParamRe.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") || params["realm"] == "" {
+		return "", fmt.Errorf("unsupported registry authentication: %q", challenge)
+	}
+	u, err := neturl.Parse(params["realm"])
+	if err != nil {
+		return "", fmt.Errorf("invalid registry token realm: %s", params["realm"])
+	}
+	query := u.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", "repository:"+name+":pull")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// grant gets a bearer token from tokenURL, anonymously without a token
+func (o *OCI) grant(ctx context.Context, tokenURL, token string) (string, error) {
+	realm := o.BaseProvider
+	realm.auth = ociBasicAuth
+	var res struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := realm.get(ctx, tokenURL, token, &res); err != nil {
+		return "", fmt.Errorf("failed to get registry token: %w", err)
+	}
+	if res.Token == "" {
+		res.Token = res.AccessToken
+	}
+	return res.Token, nil
+}
+
+// GetRepo pulls a single page of tags anonymously, only a repository
+// denied without the token is private
+func (o *OCI) GetRepo(ctx context.Context, user, repo, token string) (*RepoInfo, error) {
+	if repo == "" {
+		return nil, fmt.Errorf("repository is required")
+	}
+	name := o.name(user, repo)
+	tokenURL, err := o.challenge(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repo info: %w", err)
+	}
+	info := &RepoInfo{TokenURL: tokenURL}
+	bearer := ""
+	if tokenURL != "" {
+		bearer, err = o.grant(ctx, tokenURL, "")
+	}
+	if err == nil {
+		err = o.probe(ctx, name, bearer)
+	}
+	switch {
+	case err == nil:
+		return info, nil
+	case token == "" || tokenURL == "" || !errors.Is(err, errDenied):
+		return nil, fmt.Errorf("failed to get repo info: %w", err)
+	}
+	bearer, err = o.grant(ctx, tokenURL, token)
+	if err == nil {
+		err = o.probe(ctx, name, bearer)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repo info: %w", err)
+	}
+	info.Private = true
+	return info, nil
+}
+
+// probe checks that name can be pulled with the bearer token
+func (o *OCI) probe(ctx context.Context, name, bearer string) error {
+	return o.get(ctx, fmt.Sprintf("%s/v2/%s/tags/list?n=1", o.BaseURL, name), bearer, nil)
+}
+
+func (o *OCI) GetReleaseAssets(ctx context.Context, user, repo, release, token string) (string, []Asset, error) {
+	name := o.name(user, repo)
+	bearer, _, err := o.authorize(ctx, name, token)
+	if err != nil {
+		return "", nil, err
+	}
+	releases, err := o.releases(ctx, name, bearer)
+	if err != nil {
+		return "", nil, err
+	}
+	r, err := findRelease(releases, release)
+	if release == "" || release == "latest" {
+		// without versioned tags, only the tag called latest is the
+		// latest, not whichever tag such as main or sha-abc123 sorts first
+		if _, verr := semver.Parse(r.Tag); err != nil || verr != nil {
+			r, err = Release{}, fmt.Errorf("no releases found")
+			for _, t := range releases {
+				if t.Tag == "latest" {
+					r, err = t, nil
+				}
+			}
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	manifest, err := o.manifest(ctx, name, r.Tag, bearer)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(manifest.Manifests) == 0 {
+		return r.Tag, o.assets(name, manifest, "", ""), nil
+	}
+	assets := []Asset{}
+	for _, m := range manifest.Manifests {
+		os, arch := "", ""
+		if m.Platform != nil {
+			os, arch = m.Platform.OS, m.Platform.Architecture
+			// 32-bit ARM goes by version, as in armv7
+			if arch == "arm" && m.Platform.Variant != "" {
+				arch += m.Platform.Variant
+			}
+		}
+		// buildx stores attestations as manifests of an unknown platform
+		if os == ociUnknownPlatform {
+			continue
+		}
+		child, err := o.manifest(ctx, name, m.Digest, bearer)
+		if err != nil {
+			return "", nil, err
+		}
+		assets = append(assets, o.assets(name, child, os, arch)...)
+	}
+	return r.Tag, assets, nil
+}
+
+func (o *OCI) ListReleases(ctx context.Context, user, repo, token string) ([]Release, error) {
+	name := o.name(user, repo)
+	bearer, _, err := o.authorize(ctx, name, token)
+	if err != nil {
+		return nil, err
+	}
+	return o.releases(ctx, name, bearer)
+}
+
+// releases returns the tags of name, highest version first
+func (o *OCI) releases(ctx context.Context, name, bearer string) ([]Release, error) {
+	tags, err := o.tags(ctx, name, bearer)
+	if err != nil {
+		return nil, err
+	}
+	releases := []Release{}
+	for _, tag := range tags {
+		if ociSignatureTagRe.MatchString(tag) {
+			continue
+		}
+		v, err := semver.Parse(tag)
+		releases = append(releases, Release{Tag: tag, Name: tag, Prerelease: err == nil && v.IsPrerelease()})
+	}
+	sortReleases(releases)
+	return releases, nil
+}
+
+func (o *OCI) tags(ctx context.Context, name, bearer string) ([]string, error) {
+	tags := []string{}
+	url := fmt.Sprintf("%s/v2/%s/tags/list?n=100", o.BaseURL, name)
+	for page := 0; url != "" && page < maxPages; page++ {
+		var res struct {
+			Tags []string `json:"tags"`
+		}
+		next, err := o.getPage(ctx, url, bearer, &res)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, res.Tags...)
+		url = next
+	}
+	return tags, nil
+}
+
+func (o *OCI) manifest(ctx context.Context, name, ref, bearer string) (ociManifest, error) {
+	var m ociManifest
+	err := o.get(ctx, fmt.Sprintf("%s/v2/%s/manifests/%s", o.BaseURL, name, neturl.PathEscape(ref)), bearer, &m)
+	return m, err
+}
+
+// assets returns the titled layers of m, unnamed layers such as image
+// file systems are left out
+func (o *OCI) assets(name string, m ociManifest, os, arch string) []Asset {
+	assets := []Asset{}
+	for _, l := range m.Layers {
+		title := l.Annotations[ociTitleAnnotation]
+		if title == "" {
+			continue
+		}
+		u := fmt.Sprintf("%s/v2/%s/blobs/%s", o.BaseURL, name, l.Digest)
+		assets = append(assets, Asset{Name: title, Size: l.Size, OS: os, Arch: arch, URL: u, DownloadURL: u})
+	}
+	return assets
+}
@@ -1,28 +1,86 @@
 package provider
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/aljabri00056/installer/logger"
+)
+
+const (
+	// maxPages bounds how many pages of a listing are fetched
+	maxPages = 50
+	// maxRetries bounds how often a request failing with a transient
+	// server error is repeated
+	maxRetries = 2
 )
 
+// errNotFound is wrapped by the error of requests answered with 404
+var errNotFound = errors.New("not found")
+
+// errDenied is wrapped by the error of requests answered with 401 or 403,
+// other than for rate limits
+var errDenied = errors.New("access denied")
+
+// retryBackoff is the wait before the first retry, it doubles with
+// every further one
+var retryBackoff = 500 * time.Millisecond
+
 type RepoInfo struct {
 	Private bool
+	// TokenURL is where downloads get a bearer token from, OCI
+	// registries want one even for public artifacts
+	TokenURL string
 }
 
 type Asset struct {
-	Size        int
-	Name        string
-	OS          string
-	Arch        string
+	Size int
+	Name string
+	OS   string
+	Arch string
+	// Libc is the C library a Linux build links against, gnu, musl or
+	// static, empty when the name doesn't say
+	Libc        string
 	Type        string
 	URL         string
 	DownloadURL string
+	// Expires is when a presigned URL stops working, zero if it doesn't
+	Expires time.Time
+}
+
+// Package is the kind of package of the asset, deb, rpm or apk for the
+// system package managers or appimage, empty for archives and binaries
+func (a Asset) Package() string {
+	switch strings.ToLower(a.Type) {
+	case ".deb", ".rpm", ".apk", ".appimage":
+		return strings.ToLower(a.Type[1:])
+	}
+	return ""
 }
 
 func (a Asset) Key() string {
-	return a.OS + "/" + a.Arch
+	key := a.OS + "/" + a.Arch
+	for _, s := range []string{a.Libc, a.Package()} {
+		if s != "" {
+			key += "/" + s
+		}
+	}
+	return key
+}
+
+// ScriptKey is Key as the install scripts spell platforms, e.g.
+// linux_amd64_musl
+func (a Asset) ScriptKey() string {
+	return strings.ReplaceAll(a.Key(), "/", "_")
 }
 
 func (a Asset) DisplayKey() string {
@@ -30,7 +88,13 @@ func (a Asset) DisplayKey() string {
 	if os == "darwin" {
 		os = "macOS"
 	}
-	return os + "/" + a.Arch
+	key := os + "/" + a.Arch
+	for _, s := range []string{a.Libc, a.Package()} {
+		if s != "" {
+			key += " (" + s + ")"
+		}
+	}
+	return key
 }
 
 func (a Asset) Is32Bit() bool {
@@ -45,37 +109,213 @@ func (a Asset) IsMacM1() bool {
 	return a.IsMac() && a.Arch == "arm64"
 }
 
+// Release is a release of a repository
+type Release struct {
+	Tag  string
+	Name string
+	// Prerelease is set for releases marked as not production ready
+	Prerelease bool
+	// Draft is set for releases which are not published yet
+	Draft     bool
+	Created   time.Time
+	Published time.Time
+	Assets    []Asset
+}
+
 type Provider interface {
-	GetRepo(user, repo, token string) (*RepoInfo, error)
-	GetReleaseAssets(user, repo, release, token string) (string, []Asset, error)
+	GetRepo(ctx context.Context, user, repo, token string) (*RepoInfo, error)
+	GetReleaseAssets(ctx context.Context, user, repo, release, token string) (string, []Asset, error)
+	// ListReleases returns the releases of a repository, newest first
+	ListReleases(ctx context.Context, user, repo, token string) ([]Release, error)
+}
+
+type BaseProvider struct {
+	Client *http.Client
+	// onLimit is told about the rate limit of every response
+	onLimit func(token string, rl RateLimit)
+	// auth adds the token to a request, by default as
+	// "Authorization: token <token>"
+	auth func(req *http.Request, token string)
+	// header is added to every request
+	header http.Header
+	// perRequestAuth is set when the credentials change with every
+	// request, their responses are not kept for revalidation
+	perRequestAuth bool
 }
 
-type BaseProvider struct{}
+// JobTokenPrefix marks a GitLab CI job token, which is sent as JOB-TOKEN
+// rather than PRIVATE-TOKEN
+const JobTokenPrefix = "job-token:"
 
-func (p *BaseProvider) get(url string, token string, v any) error {
-	req, _ := http.NewRequest("GET", url, nil)
-	if token != "" {
-		req.Header.Set("Authorization", "token "+token)
+// basicOrBearerAuth sends "user:password" as basic auth and anything
+// else, such as access tokens, as a bearer token
+func basicOrBearerAuth(req *http.Request, token string) {
+	if strings.Contains(token, ":") {
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(token)))
+		return
 	}
+	req.Header.Set("Authorization", "Bearer "+token)
+}
+
+func (p *BaseProvider) get(ctx context.Context, url string, token string, v any) error {
+	_, err := p.fetch(ctx, "GET", url, nil, token, v)
+	return err
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// post sends body as JSON and decodes the response into v
+func (p *BaseProvider) post(ctx context.Context, url string, token string, body any, v any) error {
+	b, err := json.Marshal(body)
 	if err != nil {
-		return fmt.Errorf("request failed: %s: %s", url, err)
+		return fmt.Errorf("invalid request: %s: %s", url, err)
+	}
+	_, err = p.fetch(ctx, "POST", url, b, token, v)
+	return err
+}
+
+// getPage is get for paginated listings and returns the URL of the next
+// page, or "" on the last one
+func (p *BaseProvider) getPage(ctx context.Context, url string, token string, v any) (string, error) {
+	header, err := p.fetch(ctx, "GET", url, nil, token, v)
+	if err != nil {
+		return "", err
+	}
+	return nextPage(url, header), nil
+}
+
+func (p *BaseProvider) fetch(ctx context.Context, method, url string, body []byte, token string, v any) (http.Header, error) {
+	if p.perRequestAuth {
+		ctx = withPerRequestAuth(ctx)
+	}
+	newRequest := func() (*http.Request, error) {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url, r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request: %s: %s", url, err)
+		}
+		for name, values := range p.header {
+			req.Header[name] = values
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		switch {
+		case token == "":
+		case p.auth != nil:
+			p.auth(req, token)
+		default:
+			req.Header.Set("Authorization", "token "+token)
+		}
+		return req, nil
+	}
+
+	client := p.Client
+	if client == nil {
+		client = defaultClient
+	}
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		resp, err = client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("request failed: %s: %s", url, err)
+		}
+		if !transient(resp.StatusCode) || attempt == maxRetries {
+			break
+		}
+		resp.Body.Close()
+		wait := retryBackoff << attempt
+		logger.Debug("%s: %s, retrying in %s", url, resp.Status, wait)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("request failed: %s: %s", url, ctx.Err())
+		case <-time.After(wait):
+		}
 	}
 	defer resp.Body.Close()
 
+	if rl, ok := parseRateLimit(resp.Header); ok {
+		if rl.Remaining < rl.Limit/10 {
+			logger.Debug("%s: %d of %d requests left until %s", url, rl.Remaining, rl.Limit, rl.Reset.Format(time.RFC3339))
+		}
+		if p.onLimit != nil {
+			p.onLimit(token, rl)
+		}
+	}
+	if err := rateLimited(resp, time.Now()); err != nil {
+		return nil, err
+	}
 	if resp.StatusCode == 404 {
-		return fmt.Errorf("not found: url %s", url)
+		return nil, fmt.Errorf("%w: url %s", errNotFound, url)
+	}
+	if resp.StatusCode == 401 || resp.StatusCode == 403 {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%w: %s %s", errDenied, http.StatusText(resp.StatusCode), string(b))
 	}
 	if resp.StatusCode != 200 {
 		b, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("%s %s", http.StatusText(resp.StatusCode), string(b))
+		return nil, fmt.Errorf("%s %s", http.StatusText(resp.StatusCode), string(b))
 	}
-	if v != nil {
+	if raw, ok := v.(*[]byte); ok {
+		// non-JSON responses, such as directory listings
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("request failed: %s: %s", url, err)
+		}
+		*raw = b
+	} else if v != nil {
 		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
-			return fmt.Errorf("decode failed: %s: %s", url, err)
+			return nil, fmt.Errorf("decode failed: %s: %s", url, err)
+		}
+	}
+	return resp.Header, nil
+}
+
+// transient reports whether a request failing with code may succeed
+// when repeated
+func transient(code int) bool {
+	switch code {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// nextPage finds the next page of a listing. GitHub, Gitea and OCI
+// registries link to it in the Link header, GitLab sends its number in
+// X-Next-Page.
+func nextPage(url string, header http.Header) string {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		target, params, ok := strings.Cut(link, ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		target = strings.Trim(strings.TrimSpace(target), "<>")
+		// registries link relative to the host
+		base, err := neturl.Parse(url)
+		if err != nil {
+			return target
+		}
+		next, err := base.Parse(target)
+		if err != nil {
+			return ""
+		}
+		return next.String()
+	}
+	if page := header.Get("X-Next-Page"); page != "" {
+		u, err := neturl.Parse(url)
+		if err != nil {
+			return ""
 		}
+		query := u.Query()
+		query.Set("page", page)
+		u.RawQuery = query.Encode()
+		return u.String()
 	}
-	return nil
+	return ""
 }
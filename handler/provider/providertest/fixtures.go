@@ -0,0 +1,96 @@
+package providertest
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"sort"
+)
+
+// File is an entry of a fixture archive
+type File struct {
+	Name string
+	Data []byte
+	Exec bool
+}
+
+// Program returns an executable shell script which prints its name and
+// version, standing in for a real release binary.
+func Program(name, version string) File {
+	return File{
+		Name: name,
+		Data: []byte(fmt.Sprintf("#!/bin/sh\necho %s %s\n", name, version)),
+		Exec: true,
+	}
+}
+
+// Text returns a plain, non-executable file
+func Text(name, content string) File {
+	return File{Name: name, Data: []byte(content)}
+}
+
+// TarGz builds a gzipped tarball containing files
+func TarGz(files ...File) []byte {
+	buff := bytes.Buffer{}
+	gz := gzip.NewWriter(&buff)
+	gz.Write(Tar(files...))
+	gz.Close()
+	return buff.Bytes()
+}
+
+// Tar builds an uncompressed tarball containing files, for compressing
+// with tools the standard library lacks
+func Tar(files ...File) []byte {
+	buff := bytes.Buffer{}
+	tw := tar.NewWriter(&buff)
+	for _, f := range sorted(files) {
+		mode := int64(0o644)
+		if f.Exec {
+			mode = 0o755
+		}
+		tw.WriteHeader(&tar.Header{
+			Name:     f.Name,
+			Mode:     mode,
+			Size:     int64(len(f.Data)),
+			Typeflag: tar.TypeReg,
+		})
+		tw.Write(f.Data)
+	}
+	tw.Close()
+	return buff.Bytes()
+}
+
+// Zip builds a zip archive containing files
+func Zip(files ...File) []byte {
+	buff := bytes.Buffer{}
+	zw := zip.NewWriter(&buff)
+	for _, f := range sorted(files) {
+		h := &zip.FileHeader{Name: f.Name, Method: zip.Deflate}
+		if f.Exec {
+			h.SetMode(0o755)
+		} else {
+			h.SetMode(0o644)
+		}
+		w, _ := zw.CreateHeader(h)
+		w.Write(f.Data)
+	}
+	zw.Close()
+	return buff.Bytes()
+}
+
+// Gzip compresses a single file, the way bare binaries are often shipped
+func Gzip(f File) []byte {
+	buff := bytes.Buffer{}
+	gz := gzip.NewWriter(&buff)
+	gz.Write(f.Data)
+	gz.Close()
+	return buff.Bytes()
+}
+
+func sorted(files []File) []File {
+	files = append([]File(nil), files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files
+}
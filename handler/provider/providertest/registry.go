@@ -0,0 +1,219 @@
+package providertest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ociIndexType    = "application/vnd.oci.image.index.v1+json"
+	ociManifestType = "application/vnd.oci.image.manifest.v1+json"
+	ociEmptyType    = "application/vnd.oci.empty.v1+json"
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int               `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    map[string]string `json:"platform,omitempty"`
+}
+
+// ociRelease holds the manifests of a release by digest, tagged is the
+// digest of the one the tag points at
+type ociRelease struct {
+	tagged    string
+	manifests map[string][]byte
+}
+
+func digest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// ociManifests lays out rel like oras push: one manifest with a titled
+// layer per asset, or an image index with a manifest per platform
+func ociManifests(rel Release) ociRelease {
+	o := ociRelease{manifests: map[string][]byte{}}
+	manifest := func(assets []Asset) ociDescriptor {
+		layers := []ociDescriptor{}
+		for _, a := range assets {
+			layers = append(layers, ociDescriptor{
+				MediaType:   "application/octet-stream",
+				Digest:      digest(a.Data),
+				Size:        len(a.Data),
+				Annotations: map[string]string{"org.opencontainers.image.title": a.Name},
+			})
+		}
+		b, _ := json.Marshal(map[string]any{
+			"schemaVersion": 2,
+			"mediaType":     ociManifestType,
+			"artifactType":  "application/vnd.unknown.artifact.v1",
+			"config":        ociDescriptor{MediaType: ociEmptyType, Digest: digest([]byte("{}")), Size: 2},
+			"layers":        layers,
+		})
+		o.manifests[digest(b)] = b
+		return ociDescriptor{MediaType: ociManifestType, Digest: digest(b), Size: len(b)}
+	}
+	platforms := []string{}
+	byPlatform := map[string][]Asset{}
+	for _, a := range rel.Assets {
+		if _, ok := byPlatform[a.Platform]; !ok {
+			platforms = append(platforms, a.Platform)
+		}
+		byPlatform[a.Platform] = append(byPlatform[a.Platform], a)
+	}
+	if len(byPlatform[""]) == len(rel.Assets) {
+		o.tagged = manifest(rel.Assets).Digest
+		return o
+	}
+	children := []ociDescriptor{}
+	for _, p := range platforms {
+		d := manifest(byPlatform[p])
+		if p != "" {
+			parts := strings.SplitN(p, "/", 3)
+			d.Platform = map[string]string{"os": parts[0]}
+			if len(parts) > 1 {
+				d.Platform["architecture"] = parts[1]
+			}
+			if len(parts) > 2 {
+				d.Platform["variant"] = parts[2]
+			}
+		}
+		children = append(children, d)
+	}
+	b, _ := json.Marshal(map[string]any{"schemaVersion": 2, "mediaType": ociIndexType, "manifests": children})
+	o.tagged = digest(b)
+	o.manifests[o.tagged] = b
+	return o
+}
+
+// registryToken is the bearer token handed out for pulling name
+func registryToken(name string) string {
+	return "rt-" + base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+func (s *Server) registryChallenge(w http.ResponseWriter, scope string) {
+	challenge := fmt.Sprintf(`Bearer realm="%s/token",service="providertest"`, s.URL)
+	if scope != "" {
+		challenge += fmt.Sprintf(`,scope="%s"`, scope)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeJSON(w, http.StatusUnauthorized, map[string]any{"errors": []map[string]string{{"code": "UNAUTHORIZED"}}})
+}
+
+// serveRegistry serves the token endpoint and the v2 API. Repositories
+// are named owner/name.
+func (s *Server) serveRegistry(w http.ResponseWriter, r *http.Request, path string) {
+	if path == "/token" {
+		scope := strings.Split(r.URL.Query().Get("scope"), ":")
+		if len(scope) != 3 || scope[0] != "repository" {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"errors": []map[string]string{{"code": "DENIED"}}})
+			return
+		}
+		s.mut.Lock()
+		rp, ok := s.repos[scope[1]]
+		s.mut.Unlock()
+		if ok && rp.private && s.Token != "" {
+			if _, password, ok := r.BasicAuth(); !ok || password != s.Token {
+				writeJSON(w, http.StatusUnauthorized, map[string]any{"errors": []map[string]string{{"code": "UNAUTHORIZED"}}})
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"token": registryToken(scope[1])})
+		return
+	}
+	rest := strings.TrimPrefix(path, "/v2/")
+	if rest == "" {
+		s.registryChallenge(w, "")
+		return
+	}
+	var name, kind, ref string
+	for _, k := range []string{"/tags/list", "/manifests/", "/blobs/"} {
+		if i := strings.LastIndex(rest, k); i > 0 {
+			name, kind, ref = rest[:i], strings.Trim(k, "/"), rest[i+len(k):]
+			break
+		}
+	}
+	if name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+registryToken(name) {
+		s.registryChallenge(w, "repository:"+name+":pull")
+		return
+	}
+	s.mut.Lock()
+	s.requests[name]++
+	rp, ok := s.repos[name]
+	var releases []Release
+	if ok {
+		releases = append(releases, rp.releases...)
+	}
+	s.mut.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]any{"errors": []map[string]string{{"code": "NAME_UNKNOWN"}}})
+		return
+	}
+
+	switch kind {
+	case "tags/list":
+		tags := []string{}
+		for _, rel := range releases {
+			tags = append(tags, rel.Tag)
+		}
+		sort.Strings(tags)
+		if last := r.URL.Query().Get("last"); last != "" {
+			i := sort.SearchStrings(tags, last)
+			if i < len(tags) && tags[i] == last {
+				i++
+			}
+			tags = tags[i:]
+		}
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		if s.PerPage > 0 && (n <= 0 || n > s.PerPage) {
+			n = s.PerPage
+		}
+		if n > 0 && n < len(tags) {
+			tags = tags[:n]
+			w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?last=%s&n=%d>; rel="next"`, name, tags[n-1], n))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"name": name, "tags": tags})
+	case "manifests":
+		for _, rel := range releases {
+			o := ociManifests(rel)
+			d := ref
+			if ref == rel.Tag {
+				d = o.tagged
+			}
+			if b, ok := o.manifests[d]; ok {
+				var m struct {
+					MediaType string `json:"mediaType"`
+				}
+				json.Unmarshal(b, &m)
+				w.Header().Set("Content-Type", m.MediaType)
+				w.Header().Set("Docker-Content-Digest", d)
+				w.Write(b)
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]any{"errors": []map[string]string{{"code": "MANIFEST_UNKNOWN"}}})
+	case "blobs":
+		for _, rel := range releases {
+			for _, a := range rel.Assets {
+				if digest(a.Data) == ref {
+					w.Header().Set("Content-Type", "application/octet-stream")
+					w.Header().Set("Docker-Content-Digest", ref)
+					w.Write(a.Data)
+					return
+				}
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]any{"errors": []map[string]string{{"code": "BLOB_UNKNOWN"}}})
+	}
+}
@@ -0,0 +1,966 @@
+// Package providertest implements a fake code forge for tests. It speaks
+// the subset of the GitHub, GitHub Enterprise Server, Gitea/Forgejo, GitLab, Bitbucket, SourceHut,
+// S3 and OCI registry APIs used by the provider package, plays a plain
+// file server and serves the release assets it knows about, so the whole
+// installer flow can run without network access.
+package providertest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Asset is a file attached to a release
+type Asset struct {
+	Name string
+	Data []byte
+	// Platform, as in linux/arm64 or linux/arm/v7, puts the asset into
+	// the manifest of that platform in an OCI image index
+	Platform string
+}
+
+// Release is a tagged release of a repository. Releases added later are
+// considered newer.
+type Release struct {
+	Tag        string
+	Name       string
+	Prerelease bool
+	Draft      bool
+	Published  time.Time
+	Assets     []Asset
+}
+
+type repo struct {
+	owner, name string
+	private     bool
+	releases    []Release // newest first
+}
+
+// Server is a fake forge. The GitHub API is served at URL, the Gitea API
+// at URL/api/v1 and the GitLab API at URL/api/v4. API responses carry an
+// ETag and are revalidated with If-None-Match.
+type Server struct {
+	*httptest.Server
+	// Token, when set, is required to access private repositories
+	Token string
+	// PerPage, when set, caps the page size of release listings
+	PerPage int
+	// RateLimit, when set, is the number of API requests answered before
+	// the forge starts refusing them. Like GitHub, 304s are free.
+	RateLimit int
+	// Index makes the file server describe tools with an index.json
+	// rather than directory listings
+	Index bool
+
+	mut      sync.Mutex
+	repos    map[string]*repo
+	requests map[string]int
+	used     int
+	failures int
+}
+
+// NewServer starts a fake forge with no repositories. It must be closed
+// by the caller.
+func NewServer() *Server {
+	s := &Server{
+		repos:    map[string]*repo{},
+		requests: map[string]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// AddRepo registers an empty repository
+func (s *Server) AddRepo(owner, name string, private bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.repos[owner+"/"+name] = &repo{owner: owner, name: name, private: private}
+}
+
+// SecretToken protects the repositories added with AddSecret
+const SecretToken = "s3cret"
+
+// AddSecret protects the server with SecretToken and adds the private
+// repository owner/secret, whose release v1.0.0 holds the program secret
+// for linux/amd64
+func (s *Server) AddSecret(owner string) {
+	s.Token = SecretToken
+	s.AddRepo(owner, "secret", true)
+	s.AddRelease(owner, "secret", Release{
+		Tag:    "v1.0.0",
+		Assets: []Asset{{Name: "secret_linux_amd64.tar.gz", Data: TarGz(Program("secret", "v1.0.0"))}},
+	})
+}
+
+// AddRelease adds a release to a repository, creating a public
+// repository if it does not exist yet.
+func (s *Server) AddRelease(owner, name string, r Release) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	rp, ok := s.repos[owner+"/"+name]
+	if !ok {
+		rp = &repo{owner: owner, name: name}
+		s.repos[owner+"/"+name] = rp
+	}
+	if r.Name == "" {
+		r.Name = r.Tag
+	}
+	if r.Published.IsZero() {
+		r.Published = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(len(rp.releases)) * 24 * time.Hour)
+	}
+	rp.releases = append([]Release{r}, rp.releases...)
+}
+
+// Requests returns the number of API requests made against the
+// given repository
+func (s *Server) Requests(owner, name string) int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.requests[owner+"/"+name]
+}
+
+// Fail makes the next n API requests fail with 502 Bad Gateway
+func (s *Server) Fail(n int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.failures = n
+}
+
+// GitHubAPI returns the base URL of the GitHub API
+func (s *Server) GitHubAPI() string {
+	return s.URL
+}
+
+// GitHubEnterpriseAPI returns the base URL of the GitHub Enterprise Server
+// API, the instance URL being URL
+func (s *Server) GitHubEnterpriseAPI() string {
+	return s.URL + "/api/v3"
+}
+
+// GiteaAPI returns the base URL of the Gitea/Forgejo API
+func (s *Server) GiteaAPI() string {
+	return s.URL + "/api/v1"
+}
+
+// GitLabAPI returns the base URL of the GitLab API
+func (s *Server) GitLabAPI() string {
+	return s.URL + "/api/v4"
+}
+
+// BitbucketAPI returns the base URL of the Bitbucket Cloud API. The
+// Bitbucket Server API is served at URL/rest/api/1.0.
+func (s *Server) BitbucketAPI() string {
+	return s.URL + "/2.0"
+}
+
+// SourceHutAPI returns the URL of the SourceHut GraphQL API
+func (s *Server) SourceHutAPI() string {
+	return s.URL + "/query"
+}
+
+// S3URL returns the URL of the fake S3 bucket. Repositories without an
+// owner are stored there as <tool>/<tag>/<asset>. Private tools need
+// requests signed with Token as the access key id, the signatures
+// themselves are not checked.
+func (s *Server) S3URL() string {
+	return s.URL + "/bucket"
+}
+
+// RegistryURL returns the URL of the OCI registry, the v2 API is served
+// at RegistryURL/v2. Like ghcr.io it wants bearer tokens, which it hands
+// out at URL/token, for private repositories only with Token as password.
+func (s *Server) RegistryURL() string {
+	return s.URL
+}
+
+// FilesURL returns the base URL of the file server. Repositories without
+// an owner are served there as tools, with a directory per release.
+func (s *Server) FilesURL() string {
+	return s.URL + "/files"
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	if strings.HasPrefix(path, "/download/") {
+		s.serveDownload(w, r, strings.TrimPrefix(path, "/download/"))
+		return
+	}
+	s.mut.Lock()
+	fail := s.failures > 0
+	if fail {
+		s.failures--
+	}
+	s.mut.Unlock()
+	if fail {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"message": "Bad Gateway"})
+		return
+	}
+	rec := httptest.NewRecorder()
+	s.serveAPI(rec, r, path)
+	body := rec.Body.Bytes()
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+	notModified := rec.Code == http.StatusOK && r.Header.Get("If-None-Match") == etag
+
+	s.mut.Lock()
+	limited := s.RateLimit > 0 && !notModified && s.used >= s.RateLimit
+	if s.RateLimit > 0 && !notModified && !limited {
+		s.used++
+	}
+	if s.RateLimit > 0 {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(s.RateLimit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(s.RateLimit-s.used))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	}
+	s.mut.Unlock()
+
+	switch {
+	case notModified:
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+	case limited:
+		writeJSON(w, http.StatusForbidden, map[string]string{"message": "API rate limit exceeded"})
+	default:
+		for name, values := range rec.Header() {
+			w.Header()[name] = values
+		}
+		if rec.Code == http.StatusOK {
+			w.Header().Set("ETag", etag)
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(body)
+	}
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request, path string) {
+	switch {
+	case path == "/query" && r.Method == http.MethodPost:
+		s.serveSourceHut(w, r)
+	case path == "/bucket" || strings.HasPrefix(path, "/bucket/"):
+		s.serveS3(w, r, strings.TrimPrefix(strings.TrimPrefix(path, "/bucket"), "/"))
+	case path == "/token" || strings.HasPrefix(path, "/v2/"):
+		s.serveRegistry(w, r, path)
+	case strings.HasPrefix(path, "/files/"):
+		s.serveFiles(w, r, strings.TrimPrefix(path, "/files/"))
+	case strings.HasPrefix(path, "/2.0/repositories/"):
+		s.serveBitbucket(w, r, strings.TrimPrefix(path, "/2.0/repositories/"))
+	case strings.HasPrefix(path, "/rest/api/1.0/projects/"):
+		s.serveBitbucketServer(w, r, strings.TrimPrefix(path, "/rest/api/1.0/projects/"))
+	case strings.HasPrefix(path, "/api/v4/projects/"):
+		s.serveGitLab(w, r, strings.TrimPrefix(path, "/api/v4/projects/"))
+	case strings.HasPrefix(path, "/api/v3/repos/"):
+		s.serveGitHub(w, r, s.URL+"/api/v3", strings.TrimPrefix(path, "/api/v3/repos/"))
+	case strings.HasPrefix(path, "/api/v1/repos/"):
+		s.serveGitHub(w, r, s.URL+"/api/v1", strings.TrimPrefix(path, "/api/v1/repos/"))
+	case strings.HasPrefix(path, "/repos/"):
+		s.serveGitHub(w, r, s.URL, strings.TrimPrefix(path, "/repos/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// lookup finds the repository and checks the request may access it
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request, id string) (*repo, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.requests[id]++
+	rp, ok := s.repos[id]
+	if !ok || (rp.private && !s.authorized(r, strings.HasPrefix(r.URL.Path, "/api/v4/"))) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return nil, false
+	}
+	// snapshot so handlers can run without the lock
+	cp := *rp
+	cp.releases = append([]Release(nil), rp.releases...)
+	return &cp, true
+}
+
+// authorized checks the token of r. GitLab takes PRIVATE-TOKEN, JOB-TOKEN
+// or a bearer token, the others also accept "token <token>" and basic
+// auth with the token as password.
+func (s *Server) authorized(r *http.Request, gitlab bool) bool {
+	if s.Token == "" {
+		return true
+	}
+	if gitlab && (r.Header.Get("PRIVATE-TOKEN") == s.Token || r.Header.Get("JOB-TOKEN") == s.Token) {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if strings.TrimPrefix(auth, "Bearer ") == s.Token {
+		return true
+	}
+	if _, password, ok := r.BasicAuth(); ok && password == s.Token {
+		return true
+	}
+	return !gitlab && strings.TrimPrefix(auth, "token ") == s.Token
+}
+
+func (s *Server) downloadURL(rp *repo, tag, name string) string {
+	return fmt.Sprintf("%s/download/%s/%s/%s/%s", s.URL, url.PathEscape(rp.owner), rp.name, url.PathEscape(tag), url.PathEscape(name))
+}
+
+// packageURL is the generic package registry URL of a release asset
+func (s *Server) packageURL(rp *repo, tag, name string) string {
+	return fmt.Sprintf("%s/api/v4/projects/%s/packages/generic/%s/%s/%s", s.URL, url.PathEscape(rp.owner+"/"+rp.name), rp.name, url.PathEscape(tag), url.PathEscape(name))
+}
+
+func (s *Server) serveDownload(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.SplitN(path, "/", 4)
+	if len(parts) != 4 {
+		http.NotFound(w, r)
+		return
+	}
+	owner, _ := url.PathUnescape(parts[0])
+	rp, ok := s.lookup(w, r, owner+"/"+parts[1])
+	if !ok {
+		return
+	}
+	tag, _ := url.PathUnescape(parts[2])
+	name, _ := url.PathUnescape(parts[3])
+	s.serveAsset(w, r, rp, tag, name)
+}
+
+func (s *Server) serveAsset(w http.ResponseWriter, r *http.Request, rp *repo, tag, name string) {
+	for _, rel := range rp.releases {
+		if rel.Tag != tag {
+			continue
+		}
+		for _, a := range rel.Assets {
+			if a.Name == name {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write(a.Data)
+				return
+			}
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// GitHub and Gitea share the same release JSON shapes
+
+type ghAsset struct {
+	ID                 int    `json:"id"`
+	Name               string `json:"name"`
+	Size               int    `json:"size"`
+	URL                string `json:"url"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+}
+
+type ghRelease struct {
+	TagName     string    `json:"tag_name"`
+	Name        string    `json:"name"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	CreatedAt   time.Time `json:"created_at"`
+	PublishedAt time.Time `json:"published_at"`
+	Assets      []ghAsset `json:"assets"`
+}
+
+func (s *Server) ghRelease(api string, rp *repo, rel Release) ghRelease {
+	out := ghRelease{
+		TagName:     rel.Tag,
+		Name:        rel.Name,
+		Draft:       rel.Draft,
+		Prerelease:  rel.Prerelease,
+		CreatedAt:   rel.Published,
+		PublishedAt: rel.Published,
+		Assets:      []ghAsset{},
+	}
+	for i, a := range rel.Assets {
+		out.Assets = append(out.Assets, ghAsset{
+			ID:                 i,
+			Name:               a.Name,
+			Size:               len(a.Data),
+			URL:                fmt.Sprintf("%s/repos/%s/%s/releases/assets/%s/%d", api, rp.owner, rp.name, url.PathEscape(rel.Tag), i),
+			BrowserDownloadURL: s.downloadURL(rp, rel.Tag, a.Name),
+		})
+	}
+	return out
+}
+
+func (s *Server) serveGitHub(w http.ResponseWriter, r *http.Request, api, path string) {
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	rp, ok := s.lookup(w, r, parts[0]+"/"+parts[1])
+	if !ok {
+		return
+	}
+	rest := parts[2:]
+	switch {
+	case len(rest) == 0:
+		writeJSON(w, http.StatusOK, map[string]any{
+			"name":    rp.name,
+			"private": rp.private,
+		})
+	case len(rest) == 1 && rest[0] == "releases":
+		list := []ghRelease{}
+		for _, rel := range s.page(w, r, rp.releases, false) {
+			list = append(list, s.ghRelease(api, rp, rel))
+		}
+		writeJSON(w, http.StatusOK, list)
+	case len(rest) == 2 && rest[0] == "releases" && rest[1] == "latest":
+		for _, rel := range rp.releases {
+			if !rel.Draft && !rel.Prerelease {
+				writeJSON(w, http.StatusOK, s.ghRelease(api, rp, rel))
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	case len(rest) == 3 && rest[0] == "releases" && rest[1] == "tags":
+		tag, _ := url.PathUnescape(rest[2])
+		for _, rel := range rp.releases {
+			if rel.Tag == tag {
+				writeJSON(w, http.StatusOK, s.ghRelease(api, rp, rel))
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	case len(rest) == 4 && rest[0] == "releases" && rest[1] == "assets":
+		// asset API urls are used to download from private repos
+		tag, _ := url.PathUnescape(rest[2])
+		i, _ := strconv.Atoi(rest[3])
+		for _, rel := range rp.releases {
+			if rel.Tag == tag && i < len(rel.Assets) {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write(rel.Assets[i].Data)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type glLink struct {
+	Name           string `json:"name"`
+	URL            string `json:"url"`
+	DirectAssetURL string `json:"direct_asset_url"`
+	LinkType       string `json:"link_type"`
+}
+
+type glRelease struct {
+	TagName         string    `json:"tag_name"`
+	Name            string    `json:"name"`
+	CreatedAt       time.Time `json:"created_at"`
+	ReleasedAt      time.Time `json:"released_at"`
+	UpcomingRelease bool      `json:"upcoming_release"`
+	Assets          struct {
+		Links []glLink `json:"links"`
+	} `json:"assets"`
+}
+
+func (s *Server) glRelease(rp *repo, rel Release) glRelease {
+	out := glRelease{
+		TagName:         rel.Tag,
+		Name:            rel.Name,
+		CreatedAt:       rel.Published,
+		ReleasedAt:      rel.Published,
+		UpcomingRelease: rel.Draft,
+	}
+	out.Assets.Links = []glLink{}
+	for _, a := range rel.Assets {
+		out.Assets.Links = append(out.Assets.Links, glLink{
+			Name:           a.Name,
+			URL:            s.packageURL(rp, rel.Tag, a.Name),
+			DirectAssetURL: s.downloadURL(rp, rel.Tag, a.Name),
+			LinkType:       "package",
+		})
+	}
+	return out
+}
+
+func (s *Server) serveGitLab(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(path, "/")
+	id, err := url.PathUnescape(parts[0])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	rp, ok := s.lookup(w, r, id)
+	if !ok {
+		return
+	}
+	rest := parts[1:]
+	switch {
+	case len(rest) == 0:
+		project := map[string]any{"path_with_namespace": id}
+		// like GitLab, only the full view for members has the visibility
+		if r.Header.Get("PRIVATE-TOKEN") != "" || r.Header.Get("JOB-TOKEN") != "" || r.Header.Get("Authorization") != "" {
+			project["visibility"] = "public"
+			if rp.private {
+				project["visibility"] = "private"
+			}
+		}
+		writeJSON(w, http.StatusOK, project)
+	case len(rest) == 1 && rest[0] == "releases":
+		list := []glRelease{}
+		for _, rel := range s.page(w, r, rp.releases, true) {
+			list = append(list, s.glRelease(rp, rel))
+		}
+		writeJSON(w, http.StatusOK, list)
+	case len(rest) == 2 && rest[0] == "releases":
+		tag, _ := url.PathUnescape(rest[1])
+		for _, rel := range rp.releases {
+			if rel.Tag == tag {
+				writeJSON(w, http.StatusOK, s.glRelease(rp, rel))
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "404 Not Found"})
+	case len(rest) == 5 && rest[0] == "packages" && rest[1] == "generic":
+		// the package is named after the project, its version is the tag
+		tag, _ := url.PathUnescape(rest[3])
+		name, _ := url.PathUnescape(rest[4])
+		s.serveAsset(w, r, rp, tag, name)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// page returns the requested page of releases and announces the next one
+// the way GitLab (X-Next-Page) or GitHub and Gitea (Link) do
+func (s *Server) page(w http.ResponseWriter, r *http.Request, releases []Release, gitlab bool) []Release {
+	query := r.URL.Query()
+	perPage, _ := strconv.Atoi(query.Get("per_page"))
+	if perPage <= 0 {
+		perPage = 30
+	}
+	if s.PerPage > 0 && perPage > s.PerPage {
+		perPage = s.PerPage
+	}
+	page, _ := strconv.Atoi(query.Get("page"))
+	if page <= 0 {
+		page = 1
+	}
+	lo := min((page-1)*perPage, len(releases))
+	hi := min(lo+perPage, len(releases))
+	if hi < len(releases) {
+		if gitlab {
+			w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+		} else {
+			query.Set("page", strconv.Itoa(page+1))
+			next := *r.URL
+			next.RawQuery = query.Encode()
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s>; rel="next"`, s.URL, next.RequestURI()))
+		}
+	}
+	return releases[lo:hi]
+}
+
+// Bitbucket has no releases, all release assets are served as downloads
+
+type download struct {
+	tag string
+	Asset
+	created time.Time
+}
+
+func downloads(rp *repo) []download {
+	list := []download{}
+	for _, rel := range rp.releases {
+		for _, a := range rel.Assets {
+			list = append(list, download{tag: rel.Tag, Asset: a, created: rel.Published})
+		}
+	}
+	return list
+}
+
+func (s *Server) serveBitbucket(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	rp, ok := s.lookup(w, r, parts[0]+"/"+parts[1])
+	if !ok {
+		return
+	}
+	rest := parts[2:]
+	switch {
+	case len(rest) == 0:
+		writeJSON(w, http.StatusOK, map[string]any{"slug": rp.name, "is_private": rp.private})
+	case len(rest) == 1 && rest[0] == "downloads":
+		all := downloads(rp)
+		pagelen, _ := strconv.Atoi(r.URL.Query().Get("pagelen"))
+		if pagelen <= 0 {
+			pagelen = 10
+		}
+		if s.PerPage > 0 && pagelen > s.PerPage {
+			pagelen = s.PerPage
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page <= 0 {
+			page = 1
+		}
+		lo := min((page-1)*pagelen, len(all))
+		hi := min(lo+pagelen, len(all))
+		values := []map[string]any{}
+		for _, d := range all[lo:hi] {
+			values = append(values, map[string]any{
+				"name":       d.Name,
+				"size":       len(d.Data),
+				"created_on": d.created,
+				"links": map[string]any{"self": map[string]string{
+					"href": fmt.Sprintf("%s/2.0/repositories/%s/%s/downloads/%s", s.URL, rp.owner, rp.name, url.PathEscape(d.Name)),
+				}},
+			})
+		}
+		resp := map[string]any{"values": values, "page": page, "pagelen": pagelen}
+		if hi < len(all) {
+			q := r.URL.Query()
+			q.Set("page", strconv.Itoa(page+1))
+			resp["next"] = fmt.Sprintf("%s%s?%s", s.URL, r.URL.Path, q.Encode())
+		}
+		writeJSON(w, http.StatusOK, resp)
+	case len(rest) == 2 && rest[0] == "downloads":
+		// like Bitbucket, redirect to the file storage
+		name, _ := url.PathUnescape(rest[1])
+		for _, d := range downloads(rp) {
+			if d.Name == name {
+				http.Redirect(w, r, s.downloadURL(rp, d.tag, d.Name), http.StatusFound)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveBitbucketServer serves projects/{owner}/repos/{name}, the files in
+// its downloads directory are the release assets
+func (s *Server) serveBitbucketServer(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(path, "/")
+	if len(parts) < 3 || parts[1] != "repos" {
+		http.NotFound(w, r)
+		return
+	}
+	rp, ok := s.lookup(w, r, parts[0]+"/"+parts[2])
+	if !ok {
+		return
+	}
+	rest := parts[3:]
+	switch {
+	case len(rest) == 0:
+		writeJSON(w, http.StatusOK, map[string]any{"slug": rp.name, "public": !rp.private})
+	case len(rest) == 2 && rest[0] == "browse" && rest[1] == "downloads":
+		all := downloads(rp)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit <= 0 {
+			limit = 25
+		}
+		if s.PerPage > 0 && limit > s.PerPage {
+			limit = s.PerPage
+		}
+		start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+		lo := min(start, len(all))
+		hi := min(lo+limit, len(all))
+		values := []map[string]any{}
+		for _, d := range all[lo:hi] {
+			values = append(values, map[string]any{
+				"path": map[string]string{"name": d.Name},
+				"size": len(d.Data),
+				"type": "FILE",
+			})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"children": map[string]any{
+			"values":        values,
+			"isLastPage":    hi == len(all),
+			"nextPageStart": hi,
+		}})
+	case len(rest) == 3 && rest[0] == "raw" && rest[1] == "downloads":
+		name, _ := url.PathUnescape(rest[2])
+		for _, d := range downloads(rp) {
+			if d.Name == name {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write(d.Data)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveSourceHut answers the GraphQL queries of the SourceHut provider,
+// each release is a tag with its assets as artifacts
+func (s *Server) serveSourceHut(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query     string `json:"query"`
+		Variables struct {
+			Owner  string  `json:"owner"`
+			Repo   string  `json:"repo"`
+			Cursor *string `json:"cursor"`
+		} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"errors": []map[string]string{{"message": err.Error()}}})
+		return
+	}
+	rp, ok := s.lookup(w, r, req.Variables.Owner+"/"+req.Variables.Repo)
+	if !ok {
+		return
+	}
+	repository := map[string]any{}
+	if strings.Contains(req.Query, "visibility") {
+		repository["visibility"] = "PUBLIC"
+		if rp.private {
+			repository["visibility"] = "PRIVATE"
+		}
+	}
+	if strings.Contains(req.Query, "references") {
+		refs := []map[string]any{{"name": "refs/heads/master", "artifacts": map[string]any{"results": []any{}}}}
+		for _, rel := range rp.releases {
+			artifacts := []map[string]any{}
+			for _, a := range rel.Assets {
+				artifacts = append(artifacts, map[string]any{
+					"filename": a.Name,
+					"size":     len(a.Data),
+					"url":      s.downloadURL(rp, rel.Tag, a.Name),
+					"created":  rel.Published,
+				})
+			}
+			refs = append(refs, map[string]any{
+				"name":      "refs/tags/" + rel.Tag,
+				"artifacts": map[string]any{"results": artifacts},
+			})
+		}
+		perPage := len(refs)
+		if s.PerPage > 0 {
+			perPage = s.PerPage
+		}
+		lo := 0
+		if req.Variables.Cursor != nil {
+			lo, _ = strconv.Atoi(*req.Variables.Cursor)
+		}
+		lo = min(lo, len(refs))
+		hi := min(lo+perPage, len(refs))
+		var cursor any
+		if hi < len(refs) {
+			cursor = strconv.Itoa(hi)
+		}
+		repository["references"] = map[string]any{"results": refs[lo:hi], "cursor": cursor}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"data": map[string]any{"user": map[string]any{"repository": repository}},
+	})
+}
+
+// serveFiles serves {tool}/ and {tool}/{tag}/ as nginx style directory
+// listings, or {tool}/index.json when s.Index is set
+func (s *Server) serveFiles(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	// file servers deny protected files rather than hiding them
+	s.mut.Lock()
+	rp, ok := s.repos["/"+parts[0]]
+	denied := ok && rp.private && !s.authorized(r, false)
+	s.mut.Unlock()
+	if denied {
+		w.Header().Set("WWW-Authenticate", `Basic realm="providertest"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rp, ok = s.lookup(w, r, "/"+parts[0])
+	if !ok {
+		return
+	}
+	tag, _ := url.PathUnescape(parts[1])
+	switch {
+	case len(parts) == 2 && parts[1] == "index.json" && s.Index:
+		versions := map[string]any{}
+		for _, rel := range rp.releases {
+			assets := []map[string]any{}
+			for _, a := range rel.Assets {
+				assets = append(assets, map[string]any{"name": a.Name, "size": len(a.Data)})
+			}
+			versions[rel.Tag] = assets
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"versions": versions})
+	case len(parts) == 2 && parts[1] == "":
+		names := []string{}
+		for _, rel := range rp.releases {
+			names = append(names, rel.Tag+"/")
+		}
+		writeListing(w, r, names)
+	case len(parts) == 3 && parts[2] == "":
+		for _, rel := range rp.releases {
+			if rel.Tag != tag {
+				continue
+			}
+			names := []string{}
+			for _, a := range rel.Assets {
+				names = append(names, a.Name)
+			}
+			writeListing(w, r, names)
+			return
+		}
+		http.NotFound(w, r)
+	case len(parts) == 3:
+		name, _ := url.PathUnescape(parts[2])
+		s.serveAsset(w, r, rp, tag, name)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type s3Object struct {
+	key      string
+	data     []byte
+	private  bool
+	modified time.Time
+}
+
+// serveS3 serves the objects of the bucket and ListObjectsV2
+func (s *Server) serveS3(w http.ResponseWriter, r *http.Request, key string) {
+	auth := r.Header.Get("Authorization")
+	signed := strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential="+s.Token+"/") ||
+		(strings.HasPrefix(r.URL.Query().Get("X-Amz-Credential"), s.Token+"/") && r.URL.Query().Get("X-Amz-Signature") != "")
+	s.mut.Lock()
+	objects := []s3Object{}
+	for _, rp := range s.repos {
+		if rp.owner != "" {
+			continue
+		}
+		for _, rel := range rp.releases {
+			for _, a := range rel.Assets {
+				objects = append(objects, s3Object{rp.name + "/" + rel.Tag + "/" + a.Name, a.Data, rp.private, rel.Published})
+			}
+		}
+	}
+	s.mut.Unlock()
+	sort.Slice(objects, func(i, j int) bool { return objects[i].key < objects[j].key })
+
+	if key != "" {
+		key, _ = url.PathUnescape(key)
+		for _, o := range objects {
+			if o.key != key {
+				continue
+			}
+			if o.private && !signed {
+				writeS3Error(w, http.StatusForbidden, "AccessDenied")
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(o.data)
+			return
+		}
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("list-type") != "2" {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	type entry struct {
+		prefix string
+		s3Object
+	}
+	entries := []entry{}
+	seen := map[string]bool{}
+	for _, o := range objects {
+		if !strings.HasPrefix(o.key, prefix) {
+			continue
+		}
+		if o.private && !signed {
+			writeS3Error(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		rest := strings.TrimPrefix(o.key, prefix)
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			p := prefix + rest[:i+len(delimiter)]
+			if !seen[p] {
+				seen[p] = true
+				entries = append(entries, entry{prefix: p})
+			}
+			continue
+		}
+		entries = append(entries, entry{s3Object: o})
+	}
+	maxKeys, _ := strconv.Atoi(q.Get("max-keys"))
+	if maxKeys <= 0 {
+		maxKeys = 1000
+	}
+	if s.PerPage > 0 && maxKeys > s.PerPage {
+		maxKeys = s.PerPage
+	}
+	lo, _ := strconv.Atoi(q.Get("continuation-token"))
+	lo = min(lo, len(entries))
+	hi := min(lo+maxKeys, len(entries))
+
+	type content struct {
+		Key          string
+		Size         int
+		LastModified time.Time
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	res := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		KeyCount              int
+		IsTruncated           bool
+		NextContinuationToken string         `xml:",omitempty"`
+		Contents              []content      `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+	}{Name: "bucket", Prefix: prefix, KeyCount: hi - lo, IsTruncated: hi < len(entries)}
+	if res.IsTruncated {
+		res.NextContinuationToken = strconv.Itoa(hi)
+	}
+	for _, e := range entries[lo:hi] {
+		if e.prefix != "" {
+			res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{e.prefix})
+			continue
+		}
+		res.Contents = append(res.Contents, content{e.key, len(e.data), e.modified})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, xml.Header)
+	xml.NewEncoder(w).Encode(res)
+}
+
+func writeS3Error(w http.ResponseWriter, code int, s3Code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code></Error>", xml.Header, s3Code)
+}
+
+func writeListing(w http.ResponseWriter, r *http.Request, names []string) {
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, "<html>\n<head><title>Index of %s</title></head>\n<body>\n<h1>Index of %[1]s</h1><hr><pre><a href=\"../\">../</a>\n", r.URL.Path)
+	for _, name := range names {
+		href := url.PathEscape(strings.TrimSuffix(name, "/"))
+		if strings.HasSuffix(name, "/") {
+			href += "/"
+		}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>    01-Jan-2020 00:00    -\n", href, name)
+	}
+	fmt.Fprint(w, "</pre><hr></body>\n</html>\n")
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
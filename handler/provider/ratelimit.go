@@ -0,0 +1,76 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRetryAfter is used when a forge rate limits without saying for
+// how long
+const defaultRetryAfter = time.Minute
+
+// RateLimit is the request quota a forge reported in the
+// X-RateLimit-* headers of a response
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// parseRateLimit reads the X-RateLimit-* headers, ok is false when
+// the forge did not send them
+func parseRateLimit(header http.Header) (rl RateLimit, ok bool) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return rl, false
+	}
+	rl.Remaining = remaining
+	rl.Limit, _ = strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		rl.Reset = time.Unix(reset, 0)
+	}
+	return rl, true
+}
+
+// RateLimitError is returned when the forge refuses requests until
+// RetryAfter has passed
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, retry after %d seconds", e.Seconds())
+}
+
+// Seconds is RetryAfter rounded up to whole seconds, as used by the
+// Retry-After header
+func (e *RateLimitError) Seconds() int {
+	return int((e.RetryAfter + time.Second - 1) / time.Second)
+}
+
+// rateLimited returns a RateLimitError when resp refuses the request
+// because of a rate limit. GitHub answers 403 or 429 with either an
+// exhausted X-RateLimit-Remaining or a Retry-After header.
+func rateLimited(resp *http.Response, now time.Time) *RateLimitError {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	if after := resp.Header.Get("Retry-After"); after != "" {
+		if secs, err := strconv.Atoi(after); err == nil && secs >= 0 {
+			return &RateLimitError{RetryAfter: time.Duration(secs) * time.Second}
+		}
+		if t, err := http.ParseTime(after); err == nil {
+			return &RateLimitError{RetryAfter: max(t.Sub(now), 0)}
+		}
+	}
+	rl, ok := parseRateLimit(resp.Header)
+	switch {
+	case ok && rl.Remaining == 0 && rl.Reset.After(now):
+		return &RateLimitError{RetryAfter: rl.Reset.Sub(now)}
+	case ok && rl.Remaining == 0, resp.StatusCode == http.StatusTooManyRequests:
+		return &RateLimitError{RetryAfter: defaultRetryAfter}
+	}
+	return nil
+}
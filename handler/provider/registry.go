@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// instanceTypes are the APIs an Instance may speak
+var instanceTypes = []string{"github", "ghe", "gitlab", "forgejo", "bitbucket", "srht", "http", "s3", "oci"}
+
+var instanceNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// Instance is a named forge, served as /<name>/user/repo
+type Instance struct {
+	Name string
+	// Type is the API spoken by the forge, one of github, ghe (GitHub
+	// Enterprise Server, as are github instances not on github.com),
+	// gitlab, forgejo (also used for Gitea), bitbucket, srht (git.sr.ht),
+	// http (a plain file server, see HTTPFiles), s3 (a bucket, see S3) or
+	// oci (a container registry, see OCI)
+	Type string
+	// URL is the web URL of the forge, e.g. https://codeberg.org, the
+	// directory holding the tools of a file server, the bucket URL or the
+	// registry URL
+	URL string
+	// API overrides the API base URL derived from URL
+	API string
+	// Tokens are server side tokens for the instance
+	Tokens []string
+}
+
+// DefaultInstances are the public forges known without configuration
+var DefaultInstances = []Instance{
+	{Name: "github", Type: "github", URL: "https://github.com", API: DefaultGitHubAPI},
+	{Name: "codeberg", Type: "forgejo", URL: "https://codeberg.org", API: DefaultCodebergAPI},
+	{Name: "gitlab", Type: "gitlab", URL: "https://gitlab.com", API: DefaultGitLabAPI},
+	{Name: "bitbucket", Type: "bitbucket", URL: "https://bitbucket.org", API: DefaultBitbucketAPI},
+	{Name: "srht", Type: "srht", URL: "https://git.sr.ht", API: DefaultSourceHutAPI},
+	{Name: "ghcr", Type: "oci", URL: "https://ghcr.io"},
+}
+
+// Registry finds forge instances by name
+type Registry struct {
+	instances map[string]Instance
+}
+
+// NewRegistry creates a registry of instances. Names are case insensitive,
+// a later instance replaces an earlier one of the same name.
+func NewRegistry(instances ...Instance) (*Registry, error) {
+	r := &Registry{instances: map[string]Instance{}}
+	for _, inst := range instances {
+		inst.Name = strings.ToLower(strings.TrimSpace(inst.Name))
+		inst.Type = strings.ToLower(strings.TrimSpace(inst.Type))
+		if !instanceNameRe.MatchString(inst.Name) {
+			return nil, fmt.Errorf("invalid instance name: %q", inst.Name)
+		}
+		if !supportedType(inst.Type) {
+			return nil, fmt.Errorf("instance %s: unsupported provider type: %s (supported: %s)", inst.Name, inst.Type, strings.Join(instanceTypes, ", "))
+		}
+		r.instances[inst.Name] = inst
+	}
+	return r, nil
+}
+
+// Lookup returns the instance called name
+func (r *Registry) Lookup(name string) (Instance, bool) {
+	inst, ok := r.instances[strings.ToLower(name)]
+	return inst, ok
+}
+
+// LookupHost returns the instance whose web URL is on host. Of several
+// instances on one host, the first by name wins.
+func (r *Registry) LookupHost(host string) (Instance, bool) {
+	host = strings.ToLower(host)
+	found := Instance{}
+	for _, inst := range r.instances {
+		if host != "" && inst.Host() == host && (found.Name == "" || inst.Name < found.Name) {
+			found = inst
+		}
+	}
+	return found, found.Name != ""
+}
+
+// NewProvider creates a provider for the instance called name
+func (r *Registry) NewProvider(name string, opts ...Option) (Provider, error) {
+	inst, ok := r.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown provider instance: %s", name)
+	}
+	return inst.NewProvider(opts...)
+}
+
+func supportedType(t string) bool {
+	for _, s := range instanceTypes {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
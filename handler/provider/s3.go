@@ -0,0 +1,274 @@
+package provider
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aljabri00056/installer/handler/semver"
+)
+
+// s3PresignExpiry is how long presigned asset URLs stay valid, the longest
+// Signature Version 4 allows. Cached results are reloaded before.
+const s3PresignExpiry = 7 * 24 * time.Hour
+
+var (
+	// s3VirtualHostRe matches virtual hosted bucket URLs such as
+	// bucket.s3.eu-west-1.amazonaws.com
+	s3VirtualHostRe = regexp.MustCompile(`^[^.]+\.s3[.-]`)
+	// s3RegionRe finds the region of AWS hosts, also of the dual-stack
+	// endpoints s3.dualstack.<region>.amazonaws.com
+	s3RegionRe = regexp.MustCompile(`(?:^|\.)s3[.-](?:dualstack\.)?([a-z0-9-]+)\.amazonaws\.com$`)
+)
+
+// S3 reads releases from an S3 compatible bucket laid out as
+// <tool>/<version>/<asset>, or holding files with the version in their
+// names under <tool>/. Tokens are "access-key-id:secret-access-key",
+// optionally followed by ":session-token". Public tools are read
+// anonymously and keep plain object URLs. Only tools denied without the
+// token are signed with Signature Version 4 and get presigned URLs, which
+// carry the access key ID to the script. Session credentials are refused
+// for their downloads, the session token would be in the URL.
+type S3 struct {
+	BaseProvider
+	// BucketURL is the URL of the bucket, path style as in
+	// https://minio.example.com/bucket or virtual hosted as in
+	// https://bucket.s3.eu-west-1.amazonaws.com
+	BucketURL string
+	// Prefix is the key prefix of all tools, "" or ending in "/"
+	Prefix string
+	Region string
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int       `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// newS3 splits the bucket and key prefix off rawURL. The region is taken
+// from a "region" query parameter, an AWS host name or defaults to
+// us-east-1, which is also what MinIO uses.
+func newS3(base BaseProvider, rawURL string) (*S3, error) {
+	u, err := neturl.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 bucket URL: %s", rawURL)
+	}
+	s := &S3{BaseProvider: base, Region: u.Query().Get("region")}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+		if m := s3RegionRe.FindStringSubmatch(strings.ToLower(u.Hostname())); m != nil {
+			s.Region = m[1]
+		}
+	}
+	path := strings.Trim(u.Path, "/")
+	if !s3VirtualHostRe.MatchString(u.Hostname()) {
+		bucket, rest, _ := strings.Cut(path, "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("S3 bucket URL has no bucket: %s", rawURL)
+		}
+		u.Path = "/" + bucket
+		path = rest
+	} else {
+		u.Path = ""
+	}
+	if path != "" {
+		s.Prefix = path + "/"
+	}
+	u.RawQuery = ""
+	s.BucketURL = u.String()
+	s.auth = s.sign
+	return s, nil
+}
+
+func (s *S3) sign(req *http.Request, token string) {
+	if c, err := parseAWSCredentials(token); err == nil {
+		c.signRequest(req, s.Region, time.Now())
+	}
+}
+
+// GetRepo lists a single object of the tool anonymously, only a tool
+// denied without the token is private
+func (s *S3) GetRepo(ctx context.Context, user, repo, token string) (*RepoInfo, error) {
+	if repo == "" {
+		return nil, fmt.Errorf("tool is required")
+	}
+	if user != "" {
+		return nil, fmt.Errorf("buckets have no users, use /<instance>/%s", repo)
+	}
+	token, err := s.access(ctx, repo, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repo info: %w", err)
+	}
+	if token == "" {
+		return &RepoInfo{}, nil
+	}
+	if err := s.probe(ctx, repo, token); err != nil {
+		return nil, fmt.Errorf("failed to get repo info: %w", err)
+	}
+	return &RepoInfo{Private: true}, nil
+}
+
+// access returns the token a tool needs, "" for tools which can be listed
+// anonymously
+func (s *S3) access(ctx context.Context, repo, token string) (string, error) {
+	if token != "" {
+		if _, err := parseAWSCredentials(token); err != nil {
+			return "", err
+		}
+	}
+	err := s.probe(ctx, repo, "")
+	switch {
+	case err == nil:
+		return "", nil
+	case token == "" || !errors.Is(err, errDenied):
+		return "", err
+	}
+	return token, nil
+}
+
+// probe checks there is an object under the prefix of a tool
+func (s *S3) probe(ctx context.Context, tool, token string) error {
+	prefix := s.Prefix + tool + "/"
+	query := neturl.Values{"list-type": {"2"}, "prefix": {prefix}, "max-keys": {"1"}}
+	var body []byte
+	if err := s.get(ctx, s.BucketURL+"/?"+canonicalQuery(query), token, &body); err != nil {
+		return err
+	}
+	var res s3ListResult
+	if err := xml.Unmarshal(body, &res); err != nil {
+		return fmt.Errorf("decode failed: %s: %s", s.BucketURL, err)
+	}
+	if len(res.Contents) == 0 {
+		return fmt.Errorf("%w: no objects under %s", errNotFound, prefix)
+	}
+	return nil
+}
+
+func (s *S3) GetReleaseAssets(ctx context.Context, user, repo, release, token string) (string, []Asset, error) {
+	token, err := s.access(ctx, repo, token)
+	if err != nil {
+		return "", nil, err
+	}
+	releases, err := s.releases(ctx, repo, token)
+	if err != nil {
+		return "", nil, err
+	}
+	r, err := findRelease(releases, release)
+	if err != nil {
+		return "", nil, err
+	}
+	if r.Assets == nil {
+		// a version prefix, listed on demand
+		files, _, err := s.list(ctx, s.Prefix+repo+"/"+r.Tag+"/", token)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, f := range files {
+			r.Assets = append(r.Assets, f.Asset)
+		}
+	}
+	if token != "" {
+		c, err := parseAWSCredentials(token)
+		if err != nil {
+			return "", nil, err
+		}
+		// presigned URLs end up in scripts, which may show the access key
+		// ID but never a session token
+		if c.session != "" {
+			return "", nil, fmt.Errorf("S3 session credentials can not presign downloads, use a long-term access key")
+		}
+		now := time.Now()
+		for i, a := range r.Assets {
+			u, err := neturl.Parse(a.URL)
+			if err != nil {
+				return "", nil, err
+			}
+			r.Assets[i].URL = c.presign(u, s.Region, now, s3PresignExpiry)
+			r.Assets[i].DownloadURL = r.Assets[i].URL
+			r.Assets[i].Expires = now.Add(s3PresignExpiry)
+		}
+	}
+	return r.Tag, r.Assets, nil
+}
+
+// ListReleases returns the versions of a tool, highest first. Releases of
+// version prefixes come without assets, GetReleaseAssets lists them.
+func (s *S3) ListReleases(ctx context.Context, user, repo, token string) ([]Release, error) {
+	token, err := s.access(ctx, repo, token)
+	if err != nil {
+		return nil, err
+	}
+	return s.releases(ctx, repo, token)
+}
+
+func (s *S3) releases(ctx context.Context, repo, token string) ([]Release, error) {
+	files, dirs, err := s.list(ctx, s.Prefix+repo+"/", token)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 && len(dirs) == 0 {
+		return nil, fmt.Errorf("%w: no objects under %s%s/", errNotFound, s.Prefix, repo)
+	}
+	releases := filesToReleases(files)
+	for _, d := range dirs {
+		v, err := semver.Parse(d)
+		if err != nil {
+			continue
+		}
+		releases = append(releases, Release{Tag: d, Name: d, Prerelease: v.IsPrerelease()})
+	}
+	sortReleases(releases)
+	return releases, nil
+}
+
+// list returns the objects directly under prefix and the names of the
+// prefixes below it
+func (s *S3) list(ctx context.Context, prefix, token string) ([]file, []string, error) {
+	files := []file{}
+	dirs := []string{}
+	continuation := ""
+	for page := 0; page < maxPages; page++ {
+		query := neturl.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+		if continuation != "" {
+			query.Set("continuation-token", continuation)
+		}
+		var body []byte
+		if err := s.get(ctx, s.BucketURL+"/?"+canonicalQuery(query), token, &body); err != nil {
+			return nil, nil, err
+		}
+		var res s3ListResult
+		if err := xml.Unmarshal(body, &res); err != nil {
+			return nil, nil, fmt.Errorf("decode failed: %s: %s", s.BucketURL, err)
+		}
+		for _, o := range res.Contents {
+			name := strings.TrimPrefix(o.Key, prefix)
+			if name == "" {
+				continue
+			}
+			u := s.BucketURL + "/" + awsEscape(o.Key, false)
+			files = append(files, file{Asset: Asset{Name: name, Size: o.Size, URL: u, DownloadURL: u}, Modified: o.LastModified})
+		}
+		for _, p := range res.CommonPrefixes {
+			dirs = append(dirs, strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/"))
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		continuation = res.NextContinuationToken
+	}
+	return files, dirs, nil
+}
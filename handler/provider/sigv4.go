@@ -0,0 +1,168 @@
+package provider
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	amzDateFormat = "20060102T150405Z"
+	// emptySHA256 is the payload hash of requests without a body
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// awsCredentials sign requests with AWS Signature Version 4
+type awsCredentials struct {
+	id, secret, session string
+}
+
+// parseAWSCredentials reads "access-key-id:secret-access-key", optionally
+// followed by ":session-token"
+func parseAWSCredentials(token string) (awsCredentials, error) {
+	id, rest, ok := strings.Cut(token, ":")
+	secret, session, _ := strings.Cut(rest, ":")
+	if !ok || id == "" || secret == "" {
+		return awsCredentials{}, fmt.Errorf("S3 tokens are access-key-id:secret-access-key")
+	}
+	return awsCredentials{id: id, secret: secret, session: session}, nil
+}
+
+func (c awsCredentials) scope(t time.Time, region string) string {
+	return t.Format("20060102") + "/" + region + "/s3/aws4_request"
+}
+
+// signature signs the canonical request made at t
+func (c awsCredentials) signature(t time.Time, region, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	toSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		t.Format(amzDateFormat),
+		c.scope(t, region),
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+	key := hmacSHA256([]byte("AWS4"+c.secret), t.Format("20060102"))
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+// signRequest adds the Authorization header to a request without a body
+func (c awsCredentials) signRequest(req *http.Request, region string, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if c.session != "" {
+		req.Header.Set("X-Amz-Security-Token", c.session)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signed,
+		emptySHA256,
+	}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.id, c.scope(t, region), signed, c.signature(t, region, canonical)))
+}
+
+// presign returns a URL which GETs u without further credentials until
+// expires after t
+func (c awsCredentials) presign(u *neturl.URL, region string, t time.Time, expires time.Duration) string {
+	t = t.UTC()
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", c.id+"/"+c.scope(t, region))
+	query.Set("X-Amz-Date", t.Format(amzDateFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if c.session != "" {
+		query.Set("X-Amz-Security-Token", c.session)
+	}
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		canonicalURI(u),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	query.Set("X-Amz-Signature", c.signature(t, region, canonical))
+	presigned := *u
+	presigned.RawQuery = canonicalQuery(query)
+	return presigned.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalURI(u *neturl.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	return awsEscape(u.Path, false)
+}
+
+// canonicalQuery encodes query sorted by name, as both the URL and the
+// signature need it
+func canonicalQuery(query neturl.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := []string{}
+	for _, name := range names {
+		values := append([]string{}, query[name]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsEscape(name, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes everything but unreserved characters, and
+// slashes unless escapeSlash is set
+func awsEscape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
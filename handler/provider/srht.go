@@ -0,0 +1,159 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aljabri00056/installer/handler/semver"
+)
+
+// SourceHut reads the artifacts attached to the tags of a git.sr.ht
+// repository through its GraphQL API. Each tag is a release.
+type SourceHut struct {
+	BaseProvider
+	// BaseURL is the GraphQL endpoint, e.g. https://git.sr.ht/query
+	BaseURL string
+}
+
+const srhtRepoQuery = `query($owner: String!, $repo: String!) {
+  user(username: $owner) {
+    repository(name: $repo) { visibility }
+  }
+}`
+
+const srhtRefsQuery = `query($owner: String!, $repo: String!, $cursor: Cursor) {
+  user(username: $owner) {
+    repository(name: $repo) {
+      references(cursor: $cursor) {
+        results {
+          name
+          artifacts { results { filename size url created } }
+        }
+        cursor
+      }
+    }
+  }
+}`
+
+type srhtArtifact struct {
+	Filename string    `json:"filename"`
+	Size     int       `json:"size"`
+	URL      string    `json:"url"`
+	Created  time.Time `json:"created"`
+}
+
+type srhtRef struct {
+	Name      string `json:"name"`
+	Artifacts struct {
+		Results []srhtArtifact `json:"results"`
+	} `json:"artifacts"`
+}
+
+type srhtRepo struct {
+	Visibility string `json:"visibility"`
+	References struct {
+		Results []srhtRef `json:"results"`
+		Cursor  *string   `json:"cursor"`
+	} `json:"references"`
+}
+
+type srhtResponse struct {
+	Data struct {
+		User *struct {
+			Repository *srhtRepo `json:"repository"`
+		} `json:"user"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// srhtAuth sends personal access tokens as bearer tokens
+func srhtAuth(req *http.Request, token string) {
+	req.Header.Set("Authorization", "Bearer "+token)
+}
+
+// query runs a GraphQL query about a repository, user may be given with
+// or without the leading "~"
+func (s *SourceHut) query(ctx context.Context, query, user, repo, token string, vars map[string]any) (*srhtRepo, error) {
+	if vars == nil {
+		vars = map[string]any{}
+	}
+	vars["owner"] = strings.TrimPrefix(user, "~")
+	vars["repo"] = repo
+	var resp srhtResponse
+	if err := s.post(ctx, s.BaseURL, token, map[string]any{"query": query, "variables": vars}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("query failed: %s", resp.Errors[0].Message)
+	}
+	if resp.Data.User == nil || resp.Data.User.Repository == nil {
+		return nil, fmt.Errorf("not found: repository ~%s/%s", strings.TrimPrefix(user, "~"), repo)
+	}
+	return resp.Data.User.Repository, nil
+}
+
+func (s *SourceHut) GetRepo(ctx context.Context, user, repo, token string) (*RepoInfo, error) {
+	if user == "" || repo == "" {
+		return nil, fmt.Errorf("user and repo are required")
+	}
+	res, err := s.query(ctx, srhtRepoQuery, user, repo, token, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repo info: %w", err)
+	}
+	// unlisted repositories can be read by anyone with the link
+	return &RepoInfo{Private: res.Visibility == "PRIVATE"}, nil
+}
+
+func (s *SourceHut) GetReleaseAssets(ctx context.Context, user, repo, release, token string) (string, []Asset, error) {
+	releases, err := s.ListReleases(ctx, user, repo, token)
+	if err != nil {
+		return "", nil, err
+	}
+	r, err := findRelease(releases, release)
+	if err != nil {
+		return "", nil, err
+	}
+	return r.Tag, r.Assets, nil
+}
+
+// ListReleases returns the tags of the repository, highest version first.
+// Tags which are not versions come last.
+func (s *SourceHut) ListReleases(ctx context.Context, user, repo, token string) ([]Release, error) {
+	releases := []Release{}
+	var cursor *string
+	for page := 0; page < maxPages; page++ {
+		res, err := s.query(ctx, srhtRefsQuery, user, repo, token, map[string]any{"cursor": cursor})
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range res.References.Results {
+			tag, ok := strings.CutPrefix(ref.Name, "refs/tags/")
+			if !ok {
+				continue
+			}
+			r := Release{Tag: tag, Name: tag, Assets: []Asset{}}
+			if v, err := semver.Parse(tag); err == nil {
+				r.Prerelease = v.IsPrerelease()
+			}
+			for _, a := range ref.Artifacts.Results {
+				r.Assets = append(r.Assets, Asset{Name: a.Filename, Size: a.Size, URL: a.URL, DownloadURL: a.URL})
+				if a.Created.After(r.Published) {
+					r.Created = a.Created
+					r.Published = a.Created
+				}
+			}
+			releases = append(releases, r)
+		}
+		if res.References.Cursor == nil {
+			break
+		}
+		cursor = res.References.Cursor
+	}
+	sortReleases(releases)
+	return releases, nil
+}
@@ -0,0 +1,81 @@
+package provider
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenPool shares a set of server side tokens between requests. It hands
+// out the token with the most requests left, as last reported by the forge.
+type TokenPool struct {
+	mut    sync.Mutex
+	tokens []string
+	limits map[string]RateLimit
+	next   int
+}
+
+// NewTokenPool creates a pool of tokens, empty tokens are ignored
+func NewTokenPool(tokens ...string) *TokenPool {
+	p := &TokenPool{limits: map[string]RateLimit{}}
+	for _, t := range tokens {
+		if t != "" {
+			p.tokens = append(p.tokens, t)
+		}
+	}
+	return p
+}
+
+// Len returns the number of tokens in the pool
+func (p *TokenPool) Len() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.tokens)
+}
+
+// Token returns the token with the most requests left. Tokens which have
+// not been used yet come first, ties are broken round robin. It returns ""
+// for an empty pool.
+func (p *TokenPool) Token() string {
+	if p == nil || len(p.tokens) == 0 {
+		return ""
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	best, bestLeft := -1, 0
+	for i := range p.tokens {
+		n := (p.next + i) % len(p.tokens)
+		left := p.remaining(p.tokens[n])
+		if best == -1 || left > bestLeft {
+			best, bestLeft = n, left
+		}
+	}
+	p.next = (best + 1) % len(p.tokens)
+	return p.tokens[best]
+}
+
+// remaining is the number of requests token has left, unknown when it has
+// not been used yet or its limit has been reset since
+func (p *TokenPool) remaining(token string) int {
+	rl, ok := p.limits[token]
+	if !ok || (!rl.Reset.IsZero() && time.Now().After(rl.Reset)) {
+		return int(^uint(0) >> 1)
+	}
+	return rl.Remaining
+}
+
+// Observe records the rate limit the forge reported for token. It is
+// meant to be passed to WithRateLimitHook.
+func (p *TokenPool) Observe(token string, rl RateLimit) {
+	if p == nil {
+		return
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	for _, t := range p.tokens {
+		if t == token {
+			p.limits[token] = rl
+			return
+		}
+	}
+}
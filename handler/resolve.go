@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aljabri00056/installer/handler/provider"
+	"github.com/aljabri00056/installer/handler/semver"
+	"github.com/aljabri00056/installer/logger"
+)
+
+// release channels
+const (
+	channelStable     = "stable"
+	channelPrerelease = "prerelease"
+	channelNightly    = "nightly"
+)
+
+// onChannel reports whether r may be installed from channel. Drafts
+// are never installed.
+func onChannel(r provider.Release, channel string) bool {
+	if r.Draft {
+		return false
+	}
+	switch channel {
+	case channelPrerelease:
+		return true
+	case channelNightly:
+		return strings.Contains(strings.ToLower(r.Tag+" "+r.Name), "nightly")
+	}
+	return !r.Prerelease
+}
+
+// resolveChannel returns the tag of the newest release on q.Channel
+func resolveChannel(ctx context.Context, p provider.Provider, q Query, token string) (string, error) {
+	releases, err := p.ListReleases(ctx, q.User, q.Program, token)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range releases {
+		if onChannel(r, q.Channel) {
+			logger.Debug("resolved %s/%s@latest on %s to %s", q.User, q.Program, q.Channel, r.Tag)
+			return r.Tag, nil
+		}
+	}
+	return "", fmt.Errorf("no %s release found", q.Channel)
+}
+
+// resolveConstraint returns the tag of the highest release on q.Channel
+// which satisfies the version constraint in q.Release. Tags which are not
+// semantic versions are ignored.
+func resolveConstraint(ctx context.Context, p provider.Provider, q Query, token string) (string, error) {
+	c, err := semver.ParseConstraint(q.Release)
+	if err != nil {
+		return "", err
+	}
+	if q.Channel == channelPrerelease || q.Channel == channelNightly {
+		c = c.WithPrereleases()
+	}
+	releases, err := p.ListReleases(ctx, q.User, q.Program, token)
+	if err != nil {
+		return "", err
+	}
+	best := ""
+	bestVersion := semver.Version{}
+	for _, r := range releases {
+		if !onChannel(r, q.Channel) {
+			continue
+		}
+		v, err := semver.Parse(r.Tag)
+		if err != nil || !c.Check(v) {
+			continue
+		}
+		if best == "" || v.Compare(bestVersion) > 0 {
+			best, bestVersion = r.Tag, v
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("no release matches %s", q.Release)
+	}
+	logger.Debug("resolved %s/%s@%s to %s", q.User, q.Program, q.Release, best)
+	return best, nil
+}
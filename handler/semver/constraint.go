@@ -0,0 +1,218 @@
+package semver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var wildcardRe = regexp.MustCompile(`(^|\.)[xX*](\.|$)`)
+
+// IsConstraint reports whether s is a version constraint rather than a
+// plain tag. Plain tags, even partial ones like "v1.2", are left alone.
+func IsConstraint(s string) bool {
+	return strings.ContainsAny(s, "^~<>=!|, ") || wildcardRe.MatchString(s)
+}
+
+type comparator struct {
+	op string // one of = != < <= > >=
+	v  Version
+}
+
+func (c comparator) check(v Version) bool {
+	n := v.Compare(c.v)
+	switch c.op {
+	case "=":
+		return n == 0
+	case "!=":
+		return n != 0
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	case ">":
+		return n > 0
+	case ">=":
+		return n >= 0
+	}
+	return false
+}
+
+// Constraint is a set of alternatives ("||"), each of which is a set of
+// comparators which must all hold
+type Constraint struct {
+	groups     [][]comparator
+	prerelease bool
+}
+
+// ParseConstraint parses s. Supported are the operators = != < <= > >= ^ ~,
+// wildcards (1.x, 1.2.*), partial versions (1.2 is 1.2.x), hyphen ranges
+// (1.2 - 1.4) and alternatives (^1 || ^2). Comparators may be separated by
+// spaces or commas.
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{}
+	for _, alt := range strings.Split(s, "||") {
+		fields := strings.Fields(strings.ReplaceAll(alt, ",", " "))
+		group := []comparator{}
+		if len(fields) == 0 {
+			return c, fmt.Errorf("invalid constraint: %s", s)
+		}
+		for i := 0; i < len(fields); i++ {
+			term := fields[i]
+			// allow a space between operator and version
+			if strings.Trim(term, "=!<>^~") == "" && i+1 < len(fields) {
+				i++
+				term += fields[i]
+			}
+			// hyphen range
+			if i+2 < len(fields) && fields[i+1] == "-" {
+				cs, err := hyphenRange(term, fields[i+2])
+				if err != nil {
+					return c, err
+				}
+				group = append(group, cs...)
+				i += 2
+				continue
+			}
+			cs, err := parseTerm(term)
+			if err != nil {
+				return c, err
+			}
+			group = append(group, cs...)
+		}
+		c.groups = append(c.groups, group)
+	}
+	return c, nil
+}
+
+// WithPrereleases returns a copy of c which also matches pre-release
+// versions outside of the ranges that explicitly name them
+func (c Constraint) WithPrereleases() Constraint {
+	c.prerelease = true
+	return c
+}
+
+// Check reports whether v satisfies c. Following npm, a pre-release version
+// only matches when a comparator of the same alternative names a pre-release
+// of the same major.minor.patch, unless WithPrereleases was used.
+func (c Constraint) Check(v Version) bool {
+	for _, group := range c.groups {
+		ok := true
+		for _, cmp := range group {
+			if !cmp.check(v) {
+				ok = false
+				break
+			}
+		}
+		if ok && (c.prerelease || !v.IsPrerelease() || namesPrerelease(group, v)) {
+			return true
+		}
+	}
+	return false
+}
+
+func namesPrerelease(group []comparator, v Version) bool {
+	for _, cmp := range group {
+		if cmp.v.IsPrerelease() && cmp.v.Major == v.Major && cmp.v.Minor == v.Minor && cmp.v.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTerm(term string) ([]comparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(term, prefix) {
+			op = prefix
+			break
+		}
+	}
+	p, err := parsePartial(strings.TrimPrefix(term, op))
+	if err != nil {
+		return nil, fmt.Errorf("invalid constraint: %s", term)
+	}
+	lower := p.v
+	if p.wild == 0 {
+		// "*" matches everything
+		if op == "" || op == "=" || op == ">=" || op == "^" || op == "~" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("invalid constraint: %s", term)
+	}
+	switch op {
+	case "", "=":
+		if p.wild == 3 {
+			return []comparator{{"=", lower}}, nil
+		}
+		return []comparator{{">=", lower}, {"<", bump(lower, p.wild-1)}}, nil
+	case "!=":
+		if p.wild != 3 {
+			return nil, fmt.Errorf("invalid constraint: %s", term)
+		}
+		return []comparator{{"!=", lower}}, nil
+	case "^":
+		upper := bump(lower, 2)
+		switch {
+		case lower.Major > 0 || p.wild == 1:
+			upper = bump(lower, 0)
+		case lower.Minor > 0 || p.wild == 2:
+			upper = bump(lower, 1)
+		}
+		return []comparator{{">=", lower}, {"<", upper}}, nil
+	case "~":
+		if p.wild == 1 {
+			return []comparator{{">=", lower}, {"<", bump(lower, 0)}}, nil
+		}
+		return []comparator{{">=", lower}, {"<", bump(lower, 1)}}, nil
+	case ">":
+		if p.wild == 3 {
+			return []comparator{{">", lower}}, nil
+		}
+		return []comparator{{">=", bump(lower, p.wild-1)}}, nil
+	case ">=":
+		return []comparator{{">=", lower}}, nil
+	case "<":
+		return []comparator{{"<", lower}}, nil
+	case "<=":
+		if p.wild == 3 {
+			return []comparator{{"<=", lower}}, nil
+		}
+		return []comparator{{"<", bump(lower, p.wild-1)}}, nil
+	}
+	return nil, fmt.Errorf("invalid constraint: %s", term)
+}
+
+func hyphenRange(from, to string) ([]comparator, error) {
+	lo, err := parsePartial(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid constraint: %s - %s", from, to)
+	}
+	hi, err := parsePartial(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid constraint: %s - %s", from, to)
+	}
+	cs := []comparator{}
+	if lo.wild > 0 {
+		cs = append(cs, comparator{">=", lo.v})
+	}
+	switch {
+	case hi.wild == 3:
+		cs = append(cs, comparator{"<=", hi.v})
+	case hi.wild > 0:
+		cs = append(cs, comparator{"<", bump(hi.v, hi.wild-1)})
+	}
+	return cs, nil
+}
+
+// bump increments the major (0), minor (1) or patch (2) number of v and
+// zeroes everything after it
+func bump(v Version, part int) Version {
+	switch part {
+	case 0:
+		return Version{Major: v.Major + 1}
+	case 1:
+		return Version{Major: v.Major, Minor: v.Minor + 1}
+	}
+	return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+}
@@ -0,0 +1,161 @@
+// Package semver parses release tags as semantic versions and matches them
+// against npm/cargo style constraints such as "^1.4", "~2.3.0", "1.x" or
+// ">=1.2 <2".
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed semantic version. Missing minor and patch numbers
+// are zero, build metadata is ignored.
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 string
+}
+
+// Parse parses s as a version, tolerating a "v" prefix and a missing minor
+// or patch number (v1.2 is 1.2.0)
+func Parse(s string) (Version, error) {
+	p, err := parsePartial(s)
+	if err != nil {
+		return Version{}, err
+	}
+	if p.explicitWild {
+		return Version{}, fmt.Errorf("invalid version: %s", s)
+	}
+	return p.v, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 when v is lower, equal or higher than o,
+// following semver precedence rules
+func (v Version) Compare(o Version) int {
+	if c := cmpInt(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := cmpInt(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := cmpInt(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	return comparePre(v.Pre, o.Pre)
+}
+
+// IsPrerelease reports whether v has a pre-release suffix
+func (v Version) IsPrerelease() bool {
+	return v.Pre != ""
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// comparePre compares dot separated pre-release identifiers, a version
+// without one has higher precedence
+func comparePre(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		ai, aErr := strconv.Atoi(as[i])
+		bi, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if c := cmpInt(ai, bi); c != 0 {
+				return c
+			}
+		case aErr == nil:
+			return -1 // numeric identifiers sort first
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return cmpInt(len(as), len(bs))
+}
+
+// partial is a version which may leave out or wildcard trailing parts
+type partial struct {
+	v Version
+	// wild is the index of the first missing or wildcard part,
+	// 3 when the version is complete
+	wild         int
+	explicitWild bool
+}
+
+func parsePartial(s string) (partial, error) {
+	orig := s
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	p := partial{wild: 3}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		p.v.Pre = s[i+1:]
+		s = s[:i]
+		if p.v.Pre == "" {
+			return p, fmt.Errorf("invalid version: %s", orig)
+		}
+	}
+	parts := strings.Split(s, ".")
+	if s == "" || len(parts) > 3 {
+		return p, fmt.Errorf("invalid version: %s", orig)
+	}
+	nums := []*int{&p.v.Major, &p.v.Minor, &p.v.Patch}
+	for i := range nums {
+		if i >= len(parts) {
+			if p.wild == 3 {
+				p.wild = i
+			}
+			continue
+		}
+		part := parts[i]
+		if part == "x" || part == "X" || part == "*" {
+			if p.wild == 3 {
+				p.wild = i
+			}
+			p.explicitWild = true
+			continue
+		}
+		if p.wild != 3 {
+			// 1.x.3 is not a version
+			return p, fmt.Errorf("invalid version: %s", orig)
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid version: %s", orig)
+		}
+		*nums[i] = n
+	}
+	if p.wild != 3 && p.v.Pre != "" {
+		return p, fmt.Errorf("invalid version: %s", orig)
+	}
+	return p, nil
+}
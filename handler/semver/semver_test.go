@@ -0,0 +1,106 @@
+package semver
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"v1.2.3", "1.2.3"},
+		{"1.2.3", "1.2.3"},
+		{"v1.2", "1.2.0"},
+		{"1", "1.0.0"},
+		{"v2.0.0-rc.1", "2.0.0-rc.1"},
+		{"1.2.3+build.5", "1.2.3"},
+	}
+	for _, tc := range tests {
+		v, err := Parse(tc.in)
+		if err != nil {
+			t.Fatalf("Parse(%s): %s", tc.in, err)
+		}
+		if v.String() != tc.out {
+			t.Fatalf("Parse(%s) = %s, want %s", tc.in, v, tc.out)
+		}
+	}
+	for _, in := range []string{"", "latest", "v1.x", "1.2.3.4", "nightly-2024", "1.2.3-"} {
+		if _, err := Parse(in); err == nil {
+			t.Fatalf("Parse(%s) should fail", in)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1.0", "2.0.0"}
+	for i := 0; i < len(ordered)-1; i++ {
+		a, _ := Parse(ordered[i])
+		b, _ := Parse(ordered[i+1])
+		if a.Compare(b) != -1 || b.Compare(a) != 1 {
+			t.Fatalf("expected %s < %s", a, b)
+		}
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		reject     []string
+	}{
+		{"^1.4", []string{"1.4.0", "v1.9.9"}, []string{"1.3.9", "2.0.0", "1.5.0-rc.1"}},
+		{"^0.4", []string{"0.4.0", "0.4.7"}, []string{"0.5.0", "0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"~2.3.0", []string{"2.3.0", "2.3.9"}, []string{"2.4.0", "2.2.9"}},
+		{"~2", []string{"2.0.0", "2.9.0"}, []string{"3.0.0"}},
+		{"1.x", []string{"1.0.0", "1.99.0"}, []string{"2.0.0", "0.9.0"}},
+		{"1.2.*", []string{"1.2.0", "1.2.5"}, []string{"1.3.0"}},
+		{"*", []string{"0.0.1", "10.0.0"}, []string{"1.0.0-rc.1"}},
+		{">=1.2 <2", []string{"1.2.0", "1.9.0"}, []string{"1.1.0", "2.0.0"}},
+		{">= 1.2, < 2", []string{"1.2.0"}, []string{"2.0.0"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.9"}},
+		{"<=1.2", []string{"1.2.9"}, []string{"1.3.0"}},
+		{"1.2 - 1.4", []string{"1.2.0", "1.4.9"}, []string{"1.5.0"}},
+		{"^1 || ^3", []string{"1.0.0", "3.1.0"}, []string{"2.0.0"}},
+		{">=1.0.0-rc.1 <2", []string{"1.0.0-rc.2", "1.0.0"}, []string{"1.1.0-rc.1"}},
+		{"!=1.2.3", []string{"1.2.4"}, []string{"1.2.3"}},
+	}
+	for _, tc := range tests {
+		c, err := ParseConstraint(tc.constraint)
+		if err != nil {
+			t.Fatalf("ParseConstraint(%s): %s", tc.constraint, err)
+		}
+		for _, s := range tc.match {
+			v, _ := Parse(s)
+			if !c.Check(v) {
+				t.Fatalf("%s should match %s", tc.constraint, s)
+			}
+		}
+		for _, s := range tc.reject {
+			v, _ := Parse(s)
+			if c.Check(v) {
+				t.Fatalf("%s should not match %s", tc.constraint, s)
+			}
+		}
+	}
+	c, _ := ParseConstraint("^1.4")
+	if v, _ := Parse("1.5.0-rc.1"); !c.WithPrereleases().Check(v) {
+		t.Fatal("expected pre-release to match")
+	}
+	for _, s := range []string{"", "^", ">=1 ||", "~x.2", "^1.2.3.4"} {
+		if _, err := ParseConstraint(s); err == nil {
+			t.Fatalf("ParseConstraint(%q) should fail", s)
+		}
+	}
+}
+
+func TestIsConstraint(t *testing.T) {
+	for _, s := range []string{"^1.4", "~2.3.0", "1.x", ">=1.2 <2", "*", "1.2.X"} {
+		if !IsConstraint(s) {
+			t.Fatalf("%s should be a constraint", s)
+		}
+	}
+	for _, s := range []string{"v1.2.3", "1.2", "latest", "nightly", "release-1.0"} {
+		if IsConstraint(s) {
+			t.Fatalf("%s should be a tag", s)
+		}
+	}
+}
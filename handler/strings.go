@@ -6,9 +6,11 @@ import (
 )
 
 var (
-	archRe    = regexp.MustCompile(`(armv8|armv7|x64|arm64|arm|386|686|amd64|x86_64|aarch64|linux64|win64)`)
-	fileExtRe = regexp.MustCompile(`(\.tar)?(\.[a-z][a-z0-9]+)$`)
+	fileExtRe = regexp.MustCompile(`\.[a-z][a-z0-9]+$`)
 	posixOSRe = regexp.MustCompile(`(darwin|linux|(net|free|open)bsd|mac|osx|windows|win)`)
+	// libcRe matches the C library of Linux builds as a word of its own or
+	// followed by the ARM ABI, as in target triples like arm-unknown-linux-gnueabihf
+	libcRe = regexp.MustCompile(`(?:^|[._-])(musl|glibc|gnu|static)(?:eabi|eabihf|abi64|x32)?(?:[._-]|$)`)
 )
 
 func getOS(s string) string {
@@ -23,22 +25,25 @@ func getOS(s string) string {
 	return o
 }
 
-func getArch(s string) string {
-	s = strings.ToLower(s)
-	a := archRe.FindString(s)
-	//arch modifications
-	if a == "linux64" || a == "x86_64" || a == "win64" || a == "x64" {
-		a = "amd64"
-	} else if a == "32" || a == "686" {
-		a = "386"
-	} else if a == "aarch64" || a == "armv8" {
-		a = "arm64"
+// getLibc returns the C library named in s, glibc being gnu
+func getLibc(s string) string {
+	m := libcRe.FindStringSubmatch(strings.ToLower(s))
+	if m == nil {
+		return ""
+	}
+	if m[1] == "glibc" {
+		return "gnu"
 	}
-	return a
+	return m[1]
 }
 
+// getFileExt returns the extension of a known file type, or else the
+// last extension of s
 func getFileExt(s string) string {
-	return fileExtRe.FindString(s)
+	if t, ok := getFileType(s); ok {
+		return t.Ext
+	}
+	return fileExtRe.FindString(strings.ToLower(s))
 }
 
 func splitHalf(s, by string) (string, string) {
@@ -11,7 +11,11 @@ func TestFilExt(t *testing.T) {
 		{"my.file.tar.bz", ".tar.bz"},
 		{"my.file.bz2", ".bz2"},
 		{"my.file.gz", ".gz"},
-		{"my.file.tar.zip", ".tar.zip"}, // :(
+		{"my.file.tar.zip", ".zip"},
+		{"my.file.tar.zst", ".tar.zst"},
+		{"my.file.7z", ".7z"},
+		{"my.file.TXZ", ".txz"},
+		{"my.file.sha256", ".sha256"},
 	}
 	for _, tc := range tests {
 		ext := getFileExt(tc.file)
@@ -34,3 +38,23 @@ func TestArch(t *testing.T) {
 		}
 	}
 }
+
+func TestLibc(t *testing.T) {
+	tests := []struct {
+		file, libc string
+	}{
+		{"ripgrep-14.1.0-x86_64-unknown-linux-musl.tar.gz", "musl"},
+		{"ripgrep-14.1.0-arm-unknown-linux-gnueabihf.tar.gz", "gnu"},
+		{"bat-v0.24.0-arm-unknown-linux-musleabihf.tar.gz", "musl"},
+		{"tool_linux_amd64_glibc.tar.gz", "gnu"},
+		{"aria2-x86_64-linux-musl_static.zip", "musl"},
+		{"tool-static-linux-arm64.tar.gz", "static"},
+		{"gnupg_linux_amd64.tar.gz", ""},
+		{"gotty_linux_amd64.tar.gz", ""},
+	}
+	for _, tc := range tests {
+		if libc := getLibc(tc.file); libc != tc.libc {
+			t.Fatalf("getLibc(%s) = %s, want %s", tc.file, libc, tc.libc)
+		}
+	}
+}
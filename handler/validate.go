@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/aljabri00056/installer/handler/semver"
+)
+
+var (
+	nameRe     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	releaseRe  = regexp.MustCompile(`^[A-Za-z0-9^~<>=!*|.,_+/ -]+$`)
+	includeRe  = regexp.MustCompile(`^[A-Za-z0-9._+ -]+$`)
+	archNameRe = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	platformRe = regexp.MustCompile(`^[a-z]+$`)
+)
+
+// validate rejects any query value that does not look like what it claims
+// to be. Templates quote everything they render regardless, this is the
+// second line of defence.
+func (q Query) validate() error {
+	if q.User != "" {
+		// GitLab users may be nested groups
+		for _, part := range strings.Split(q.User, "/") {
+			if !nameRe.MatchString(part) {
+				return fmt.Errorf("invalid user: %q", q.User)
+			}
+		}
+	}
+	if !nameRe.MatchString(q.Program) {
+		return fmt.Errorf("invalid program: %q", q.Program)
+	}
+	if q.Release != "" {
+		if !releaseRe.MatchString(q.Release) || strings.Contains(q.Release, "..") {
+			return fmt.Errorf("invalid release: %q", q.Release)
+		}
+		if semver.IsConstraint(q.Release) {
+			if _, err := semver.ParseConstraint(q.Release); err != nil {
+				return err
+			}
+		}
+	}
+	switch q.Channel {
+	case "", channelStable, channelPrerelease, channelNightly:
+	default:
+		return fmt.Errorf("invalid channel: %q (supported: stable, prerelease, nightly)", q.Channel)
+	}
+	if q.AsProgram != "" {
+		for _, name := range strings.Split(q.AsProgram, ",") {
+			name = strings.TrimSpace(name)
+			if !nameRe.MatchString(name) || strings.Trim(name, ".") == "" {
+				return fmt.Errorf("invalid program name: %q", name)
+			}
+		}
+	}
+	if q.Include != "" {
+		for _, include := range strings.Split(q.Include, ",") {
+			if !includeRe.MatchString(include) {
+				return fmt.Errorf("invalid include filter: %q", include)
+			}
+		}
+	}
+	if q.Arch != "" && !archNameRe.MatchString(q.Arch) {
+		return fmt.Errorf("invalid arch: %q", q.Arch)
+	}
+	if !platformRe.MatchString(q.Platform) {
+		return fmt.Errorf("invalid platform: %q", q.Platform)
+	}
+	switch q.Pkg {
+	case "", "auto", "deb", "rpm", "apk", "appimage":
+	default:
+		return fmt.Errorf("invalid package type: %q, want auto, deb, rpm, apk or appimage", q.Pkg)
+	}
+	return nil
+}
+
+// isDownloadURL reports whether s is an absolute http(s) URL, the only kind
+// of asset link the scripts are allowed to fetch.
+func isDownloadURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}